@@ -0,0 +1,256 @@
+package main
+
+import (
+	"CRUDWeb/models"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// apiKeyPrefix starts every api key, so leaked keys are easy to spot
+const apiKeyPrefix = "cw"
+
+// apiKeyScopes maps the scope of an api key to the role it acts with
+var apiKeyScopes = map[string]string{
+	"read":  "viewer",
+	"write": "editor",
+	"admin": "admin",
+}
+
+var errInvalidAPIKey = errors.New("invalid api key")
+
+// newAPIKey returns a random key, with the form cw_<prefix>_<secret>, and its public prefix
+func newAPIKey() (string, string, error) {
+	prefix := make([]byte, 8)
+	_, err := rand.Read(prefix)
+	if err != nil {
+		return "", "", err
+	}
+
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return "", "", err
+	}
+
+	p := hex.EncodeToString(prefix)
+	return apiKeyPrefix + "_" + p + "_" + hex.EncodeToString(secret), p, nil
+}
+
+// hashAPIKey returns the hash stored for a key. Keys are random enough that a fast hash is fine
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKeyStore finds the api keys that authenticate requests and records their use
+type apiKeyStore interface {
+	GetAPIKeyByPrefix(ctx context.Context, prefix string) (*models.APIKey, error)
+	TouchAPIKey(ctx context.Context, id int) error
+}
+
+// apiKeyStore returns the store of the api keys for the request r
+func (app *application) apiKeyStore(r *http.Request) apiKeyStore {
+	if app.apiKeys != nil {
+		return app.apiKeys
+	}
+
+	return app.db(r)
+}
+
+// apiKeyPrincipal checks an api key and returns the principal it authenticates
+func (app *application) apiKeyPrincipal(r *http.Request, rawKey string) (*principal, error) {
+	parts := strings.Split(rawKey, "_")
+	if len(parts) != 3 || parts[0] != apiKeyPrefix {
+		return nil, errInvalidAPIKey
+	}
+
+	key, err := app.apiKeyStore(r).GetAPIKeyByPrefix(r.Context(), parts[1])
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashAPIKey(rawKey)), []byte(key.KeyHash)) != 1 {
+		return nil, errInvalidAPIKey
+	}
+	if key.RevokedAt != nil {
+		return nil, errors.New("api key has been revoked")
+	}
+	if key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt) {
+		return nil, errors.New("api key has expired")
+	}
+
+	err = app.apiKeyStore(r).TouchAPIKey(r.Context(), key.ID)
+	if err != nil {
+		app.log(r).Error("recording api key use", "error", err, "api_key_id", key.ID)
+	}
+
+	return &principal{
+		Subject:  "apikey:" + key.Name,
+		Role:     apiKeyScopes[key.Scope],
+		APIKeyID: key.ID,
+	}, nil
+}
+
+type apiKeyPayload struct {
+	Name      string     `json:"name"`
+	Scope     string     `json:"scope"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// applyTo validates the payload and copies it into key
+func (p *apiKeyPayload) applyTo(key *models.APIKey) error {
+	if strings.TrimSpace(p.Name) == "" {
+		return errors.New("name is required")
+	}
+	if _, ok := apiKeyScopes[p.Scope]; !ok {
+		return fmt.Errorf("invalid scope %q", p.Scope)
+	}
+
+	key.Name = strings.TrimSpace(p.Name)
+	key.Scope = p.Scope
+	key.ExpiresAt = p.ExpiresAt
+
+	return nil
+}
+
+// getAllAPIKeys handles /v1/admin/apikeys
+func (app *application) getAllAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := app.db(r).GetAllAPIKeys(r.Context())
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, keys, "api_keys")
+}
+
+// insertAPIKey handles /v1/admin/apikeys/insert
+// the key is only returned by this call, the database keeps its hash
+func (app *application) insertAPIKey(w http.ResponseWriter, r *http.Request) {
+	var payload apiKeyPayload
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	var key models.APIKey
+	err = payload.applyTo(&key)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	rawKey, prefix, err := newAPIKey()
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	key.Prefix = prefix
+	key.KeyHash = hashAPIKey(rawKey)
+
+	if p := principalFromContext(r.Context()); p.UserID != 0 {
+		key.CreatedBy = &p.UserID
+	}
+
+	err = app.db(r).InsertAPIKey(r.Context(), &key)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	type jsonResp struct {
+		*models.APIKey
+		Key string `json:"key"`
+	}
+
+	resp := jsonResp{
+		APIKey: &key,
+		Key:    rawKey,
+	}
+	app.writeJSON(w, http.StatusOK, resp, "api_key")
+}
+
+// updateAPIKey handles /v1/admin/apikeys/update/:id
+func (app *application) updateAPIKey(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.log(r).Warn("invalid id parameter", "error", err)
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	var payload apiKeyPayload
+
+	err = json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	key, err := app.db(r).GetOneAPIKey(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	err = payload.applyTo(key)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	err = app.db(r).UpdateAPIKey(r.Context(), key)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, key, "api_key")
+}
+
+// revokeAPIKey handles /v1/admin/apikeys/revoke/:id
+func (app *application) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.log(r).Warn("invalid id parameter", "error", err)
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	err = app.db(r).RevokeAPIKey(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	type jsonResp struct {
+		OK bool `json:"ok"`
+	}
+
+	ok := jsonResp{
+		OK: true,
+	}
+	app.writeJSON(w, http.StatusOK, ok, "OK")
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"CRUDWeb/models"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memoryAPIKeyStore keeps the api keys in memory, by prefix, and counts their uses by id
+type memoryAPIKeyStore struct {
+	keys    map[string]*models.APIKey
+	touched map[int]int
+}
+
+func (s *memoryAPIKeyStore) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	key, ok := s.keys[prefix]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	return key, nil
+}
+
+func (s *memoryAPIKeyStore) TouchAPIKey(ctx context.Context, id int) error {
+	s.touched[id]++
+	return nil
+}
+
+func TestNewAPIKey(t *testing.T) {
+	key, prefix, err := newAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(key, "_")
+	if len(parts) != 3 || parts[0] != apiKeyPrefix || parts[1] != prefix || len(parts[2]) != 64 {
+		t.Errorf("newAPIKey() = %q, %q, want %s_<prefix>_<secret>", key, prefix, apiKeyPrefix)
+	}
+
+	other, _, err := newAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == key {
+		t.Error("newAPIKey() returned the same key twice")
+	}
+}
+
+func TestAPIKeyPrincipal(t *testing.T) {
+	// newKey crea una clave y su fila, con los cambios de edit
+	store := &memoryAPIKeyStore{keys: make(map[string]*models.APIKey), touched: make(map[int]int)}
+	id := 0
+	newKey := func(scope string, edit func(key *models.APIKey)) string {
+		raw, prefix, err := newAPIKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		id++
+		key := &models.APIKey{ID: id, Name: scope + " key", Prefix: prefix, KeyHash: hashAPIKey(raw), Scope: scope}
+		if edit != nil {
+			edit(key)
+		}
+		store.keys[prefix] = key
+		return raw
+	}
+
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	read := newKey("read", nil)
+	write := newKey("write", func(key *models.APIKey) { key.ExpiresAt = &future })
+	admin := newKey("admin", nil)
+	revoked := newKey("write", func(key *models.APIKey) { key.RevokedAt = &past })
+	expired := newKey("write", func(key *models.APIKey) { key.ExpiresAt = &past })
+	readParts := strings.Split(read, "_")
+
+	tests := []struct {
+		name        string
+		key         string
+		wantSubject string
+		wantRole    string
+		wantID      int
+		wantErr     string
+	}{
+		{name: "read key", key: read, wantSubject: "apikey:read key", wantRole: "viewer", wantID: 1},
+		{name: "write key before it expires", key: write, wantSubject: "apikey:write key", wantRole: "editor", wantID: 2},
+		{name: "admin key", key: admin, wantSubject: "apikey:admin key", wantRole: "admin", wantID: 3},
+		{name: "revoked", key: revoked, wantErr: "api key has been revoked"},
+		{name: "expired", key: expired, wantErr: "api key has expired"},
+		{name: "known prefix, other secret", key: readParts[0] + "_" + readParts[1] + "_" + strings.Repeat("0", 64), wantErr: errInvalidAPIKey.Error()},
+		{name: "known prefix, no secret", key: readParts[0] + "_" + readParts[1] + "_", wantErr: errInvalidAPIKey.Error()},
+		{name: "unknown prefix", key: apiKeyPrefix + "_0123456789abcdef_" + readParts[2], wantErr: errInvalidAPIKey.Error()},
+		{name: "other key prefix", key: "xx_" + readParts[1] + "_" + readParts[2], wantErr: errInvalidAPIKey.Error()},
+		{name: "not a key", key: "secret", wantErr: errInvalidAPIKey.Error()},
+	}
+
+	app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil)), apiKeys: store}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v2/games", nil)
+
+			before := store.touched[tt.wantID]
+			p, err := app.apiKeyPrincipal(r, tt.key)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("apiKeyPrincipal() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("apiKeyPrincipal() error = %v", err)
+			}
+
+			if p.Subject != tt.wantSubject || p.Role != tt.wantRole || p.APIKeyID != tt.wantID {
+				t.Errorf("principal = %+v, want %s with role %s and key %d", p, tt.wantSubject, tt.wantRole, tt.wantID)
+			}
+			if store.touched[tt.wantID] != before+1 {
+				t.Errorf("key %d used %d times, want %d", tt.wantID, store.touched[tt.wantID], before+1)
+			}
+		})
+	}
+
+	// una clave que funcionaba deja de entrar en cuanto se revoca
+	store.keys[strings.Split(admin, "_")[1]].RevokedAt = &past
+	_, err := app.apiKeyPrincipal(httptest.NewRequest(http.MethodGet, "/v2/games", nil), admin)
+	if err == nil || errors.Is(err, errInvalidAPIKey) {
+		t.Errorf("revoked admin key: error = %v, want the revocation", err)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"CRUDWeb/models"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// Page sizes of the audit log listing
+const (
+	defaultAuditPageSize = 20
+	maxAuditPageSize     = 100
+)
+
+// auditEntities are the values accepted by ?entity= of the audit log
+var auditEntities = map[string]bool{
+	models.AuditGame:  true,
+	models.AuditMedia: true,
+	models.AuditGenre: true,
+	models.AuditMode:  true,
+}
+
+// getAuditLog handles /v1/admin/audit
+// lists the changes of the catalog, the most recent first, filtered by ?entity=, ?id= and ?actor=,
+// a page at a time with ?page= and ?page_size=
+func (app *application) getAuditLog(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	filter := models.AuditFilter{
+		Entity:   qs.Get("entity"),
+		Actor:    qs.Get("actor"),
+		Page:     1,
+		PageSize: defaultAuditPageSize,
+	}
+
+	if filter.Entity != "" && !auditEntities[filter.Entity] {
+		app.errorJSON(w, r, errors.New("entity must be one of game, media, genre or mode"))
+		return
+	}
+
+	var err error
+	if id := qs.Get("id"); id != "" {
+		if filter.Entity == "" {
+			app.errorJSON(w, r, errors.New("id needs an entity"))
+			return
+		}
+		filter.EntityID, err = strconv.Atoi(id)
+		if err != nil || filter.EntityID <= 0 {
+			app.log(r).Warn("invalid id parameter", "error", err)
+			app.errorJSON(w, r, errors.New("id must be a positive integer"))
+			return
+		}
+	}
+	if page := qs.Get("page"); page != "" {
+		filter.Page, err = strconv.Atoi(page)
+		if err != nil || filter.Page < 1 {
+			app.errorJSON(w, r, errors.New("page must be a positive integer"))
+			return
+		}
+	}
+	if pageSize := qs.Get("page_size"); pageSize != "" {
+		filter.PageSize, err = strconv.Atoi(pageSize)
+		if err != nil || filter.PageSize < 1 || filter.PageSize > maxAuditPageSize {
+			app.errorJSON(w, r, errors.New("page_size must be between 1 and 100"))
+			return
+		}
+	}
+
+	entries, total, err := app.db(r).GetAuditLog(r.Context(), filter)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	type metadata struct {
+		Page         int `json:"page"`
+		PageSize     int `json:"page_size"`
+		TotalRecords int `json:"total_records"`
+		LastPage     int `json:"last_page"`
+	}
+
+	type jsonResp struct {
+		Entries  []*models.AuditEntry `json:"entries"`
+		Metadata metadata             `json:"metadata"`
+	}
+
+	resp := jsonResp{
+		Entries: entries,
+		Metadata: metadata{
+			Page:         filter.Page,
+			PageSize:     filter.PageSize,
+			TotalRecords: total,
+			LastPage:     (total + filter.PageSize - 1) / filter.PageSize,
+		},
+	}
+	app.writeJSON(w, http.StatusOK, resp, "audit")
+}
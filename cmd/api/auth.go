@@ -0,0 +1,293 @@
+package main
+
+import (
+	"CRUDWeb/models"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type contextKey string
+
+const principalContextKey = contextKey("principal")
+
+// dummyPasswordHash is compared when the user does not exist, so the response time does not tell
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// principal is the authenticated client of a request
+type principal struct {
+	Subject  string
+	UserID   int
+	Role     string
+	APIKeyID int
+}
+
+// permission is an action on the catalog a role may be allowed to do
+type permission string
+
+const (
+	permReadGames      permission = "games:read"
+	permWriteGames     permission = "games:write"
+	permDeleteGames    permission = "games:delete"
+	permManageTaxonomy permission = "taxonomy:manage"
+	permManageUsers    permission = "users:manage"
+	permAdmin          permission = "admin"
+)
+
+// roles are the permissions granted to each role. Anonymous clients can still read the catalog
+var roles = map[string][]permission{
+	"viewer": {permReadGames},
+	"editor": {permReadGames, permWriteGames},
+	"admin":  {permReadGames, permWriteGames, permDeleteGames, permManageTaxonomy, permManageUsers, permAdmin},
+}
+
+// can tells whether the principal has the permission
+func (p *principal) can(perm permission) bool {
+	for _, granted := range roles[p.Role] {
+		if granted == perm {
+			return true
+		}
+	}
+
+	return false
+}
+
+// principalFromContext returns the authenticated client of the request, or nil for anonymous requests
+func principalFromContext(ctx context.Context) *principal {
+	p, _ := ctx.Value(principalContextKey).(*principal)
+	return p
+}
+
+// authenticate reads the api key or bearer token of the request, if any, and stores its principal in the context.
+// Requests with invalid credentials are rejected, and counted against the failed authentications budget of
+// their ip; anonymous requests go on and are checked by requireAuth
+func (app *application) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+		w.Header().Add("Vary", "X-API-Key")
+
+		apiKey := r.Header.Get("X-API-Key")
+		authHeader := r.Header.Get("Authorization")
+		if apiKey == "" && authHeader == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// las credenciales fallidas se cuentan por ip: quien prueba claves no llega a tener un cliente propio
+		if app.tooManyAuthFailures(w, r) {
+			return
+		}
+		reject := func(err error) {
+			app.countAuthFailure(r)
+			app.unauthorized(w, r, err)
+		}
+
+		if apiKey != "" {
+			p, err := app.apiKeyPrincipal(r, apiKey)
+			if err != nil {
+				reject(err)
+				return
+			}
+
+			next.ServeHTTP(w, withPrincipal(r, p))
+			return
+		}
+
+		headerParts := strings.Split(authHeader, " ")
+		if len(headerParts) != 2 || !strings.EqualFold(headerParts[0], "Bearer") {
+			reject(errors.New("invalid auth header"))
+			return
+		}
+
+		p, err := app.tokenPrincipal(r, headerParts[1])
+		if err != nil {
+			reject(err)
+			return
+		}
+
+		next.ServeHTTP(w, withPrincipal(r, p))
+	})
+}
+
+// userStore finds the users that sign in and the users of tokens
+type userStore interface {
+	GetOneUser(ctx context.Context, id int) (*models.User, error)
+	GetUserByUsername(ctx context.Context, username string) (*models.User, error)
+}
+
+// userStore returns the store of the users for the request r
+func (app *application) userStore(r *http.Request) userStore {
+	if app.users != nil {
+		return app.users
+	}
+
+	return app.db(r)
+}
+
+// tokenPrincipal checks a bearer token and returns the principal of its user. The user is read on every
+// request, so the role is always the current one, deleted users are rejected, and so are the tokens
+// signed before a new password or role raised the token version of the user
+func (app *application) tokenPrincipal(r *http.Request, token string) (*principal, error) {
+	var claims jwtClaims
+	err := app.jwtKeys.Verify(token, &claims)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := app.userStore(r).GetOneUser(r.Context(), claims.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("the user of the token no longer exists")
+	}
+	if err != nil {
+		return nil, err
+	}
+	// se compara la version y no la fecha, que depende de los relojes del servidor y de la base de datos
+	if user.TokenVersion != claims.Version {
+		return nil, errors.New("the password or role of the user has changed since the token was issued, sign in again")
+	}
+
+	return &principal{
+		Subject: user.Username,
+		UserID:  user.ID,
+		Role:    user.Role,
+	}, nil
+}
+
+// withPrincipal stores the principal in the context of the request, also as the actor of the
+// changes it makes for the audit log
+func withPrincipal(r *http.Request, p *principal) *http.Request {
+	if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+		info.principal = p.Subject
+	}
+
+	ctx := context.WithValue(r.Context(), principalContextKey, p)
+	ctx = models.WithActor(ctx, p.Subject, requestIDFromContext(ctx))
+
+	return r.WithContext(ctx)
+}
+
+// requireAuth rejects anonymous requests
+func (app *application) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if principalFromContext(r.Context()) == nil {
+			app.unauthorized(w, r, errors.New("authentication required"))
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// adminOnly tells whether perm is granted to no role but admin
+func adminOnly(perm permission) bool {
+	for role, granted := range roles {
+		if role == "admin" {
+			continue
+		}
+		for _, p := range granted {
+			if p == perm {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// requirePermission rejects anonymous requests and requests whose role lacks perm. The permissions only
+// admins have also need a client certificate when mutual tls is configured, see requireClientCert
+func (app *application) requirePermission(perm permission, next http.HandlerFunc) http.HandlerFunc {
+	handler := app.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		if !principalFromContext(r.Context()).can(perm) {
+			app.errorJSON(w, r, errors.New("you are not allowed to do this"), http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	})
+
+	if adminOnly(perm) {
+		return app.requireClientCert(handler)
+	}
+
+	return handler
+}
+
+// unauthorized writes a 401 error
+func (app *application) unauthorized(w http.ResponseWriter, r *http.Request, err error) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="CRUDWeb"`)
+	app.errorJSON(w, r, err, http.StatusUnauthorized)
+}
+
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// signin handles /v1/signin. Wrong passwords are counted against the failed authentications budget of the ip
+func (app *application) signin(w http.ResponseWriter, r *http.Request) {
+	var creds credentials
+
+	err := json.NewDecoder(r.Body).Decode(&creds)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	// los intentos fallidos gastan el mismo presupuesto por ip que las credenciales invalidas de authenticate
+	if app.tooManyAuthFailures(w, r) {
+		return
+	}
+
+	// si el usuario no existe se compara igualmente un hash, para no revelarlo por el tiempo de respuesta
+	hashedPassword := dummyPasswordHash
+	user, err := app.userStore(r).GetUserByUsername(r.Context(), creds.Username)
+	if err == nil {
+		hashedPassword = []byte(user.PasswordHash)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(creds.Password))
+	if err != nil || user == nil {
+		app.countAuthFailure(r)
+		app.unauthorized(w, r, errors.New("invalid username or password"))
+		return
+	}
+
+	now := time.Now()
+	claims := jwtClaims{
+		Subject:   user.Username,
+		UserID:    user.ID,
+		Role:      user.Role,
+		Version:   user.TokenVersion,
+		Issuer:    jwtIssuer,
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		Expires:   now.Add(app.config.jwt.ttl).Unix(),
+	}
+
+	token, err := app.jwtKeys.Sign(claims)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	type jsonResp struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	resp := jsonResp{
+		Token:     token,
+		ExpiresAt: time.Unix(claims.Expires, 0).UTC(),
+	}
+	app.writeJSON(w, http.StatusOK, resp, "response")
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// runCommand runs the command given after the flags, e.g. "api -dsn=... images gc -delete"
+func (app *application) runCommand(args []string) error {
+	switch {
+	case len(args) >= 2 && args[0] == "images" && args[1] == "gc":
+		return app.runImagesGC(args[2:])
+	case len(args) >= 2 && args[0] == "trash" && args[1] == "purge":
+		return app.runTrashPurge(args[2:])
+	case len(args) >= 2 && args[0] == "users" && args[1] == "create":
+		return app.runUsersCreate(args[2:])
+	default:
+		return fmt.Errorf("unknown command %q", strings.Join(args, " "))
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix is the prefix of the environment variables that configure the api, e.g. CRUDWEB_DB_DSN for -db-dsn
+const envPrefix = "CRUDWEB_"
+
+// flagAliases are the old names of some flags, still accepted on the command line, in files and in the environment
+var flagAliases = map[string]string{
+	"dsn":    "db-dsn",
+	"images": "images-dir",
+}
+
+// envNotSettings are the CRUDWEB_* variables that are read by some command and are not settings
+var envNotSettings = map[string]bool{
+	envPrefix + "CONFIG": true,
+	userPasswordEnv:      true,
+}
+
+// secretFlags are never logged
+var secretFlags = map[string]bool{
+	"jwt-secret":           true,
+	"jwt-previous-secrets": true,
+}
+
+// loadConfig completes the flags of fs that were not given on the command line, first with the
+// config file (-config or CRUDWEB_CONFIG) and then with the CRUDWEB_* variables, so the precedence is
+// flags > environment > file > defaults. A file may nest keys: "images: {gc-interval: 1h}" sets -images-gc-interval
+func loadConfig(fs *flag.FlagSet, file string) error {
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[canonicalFlag(f.Name)] = true
+	})
+
+	if file == "" {
+		file = os.Getenv(envPrefix + "CONFIG")
+	}
+
+	values := map[string]string{}
+	if file != "" {
+		fileValues, err := readConfigFile(file)
+		if err != nil {
+			return err
+		}
+		for name, value := range fileValues {
+			name = canonicalFlag(name)
+			if !isSetting(fs, name) {
+				return fmt.Errorf("%s: unknown setting %q", file, name)
+			}
+			values[name] = value
+		}
+	}
+
+	for _, kv := range os.Environ() {
+		key, value, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(key, envPrefix) || envNotSettings[key] {
+			continue
+		}
+
+		name := canonicalFlag(strings.ReplaceAll(strings.ToLower(strings.TrimPrefix(key, envPrefix)), "_", "-"))
+		if !isSetting(fs, name) {
+			return fmt.Errorf("%s: unknown setting %q", key, name)
+		}
+		values[name] = value
+	}
+
+	for name, value := range values {
+		if explicit[name] {
+			continue
+		}
+
+		err := fs.Set(name, value)
+		if err != nil {
+			return fmt.Errorf("invalid value %q for %s: %w", redactSetting(name, value), name, err)
+		}
+	}
+
+	return nil
+}
+
+// canonicalFlag returns the current name of a flag given by an old name
+func canonicalFlag(name string) string {
+	if canonical, ok := flagAliases[name]; ok {
+		return canonical
+	}
+
+	return name
+}
+
+// isSetting tells whether name is a flag that can be set from a file or the environment
+func isSetting(fs *flag.FlagSet, name string) bool {
+	return name != "config" && fs.Lookup(name) != nil
+}
+
+// readConfigFile reads a YAML (.yaml, .yml) or TOML (.toml) file and flattens it into flag names and values
+func readConfigFile(file string) (map[string]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree map[string]interface{}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".toml":
+		err = toml.Unmarshal(data, &tree)
+	default:
+		return nil, fmt.Errorf("%s: unknown config format, use .yaml, .yml or .toml", file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	values := map[string]string{}
+	err = flattenConfig("", tree, values)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	return values, nil
+}
+
+// flattenConfig joins nested keys with "-" and turns lists into comma separated values
+func flattenConfig(prefix string, tree map[string]interface{}, values map[string]string) error {
+	for key, value := range tree {
+		name := strings.ReplaceAll(strings.ToLower(key), "_", "-")
+		if prefix != "" {
+			name = prefix + "-" + name
+		}
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			err := flattenConfig(name, v, values)
+			if err != nil {
+				return err
+			}
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[name] = strings.Join(items, ",")
+		case time.Time:
+			// las fechas sin comillas llegan ya parseadas
+			values[name] = v.Format("2006-01-02")
+		case nil:
+			return fmt.Errorf("setting %q has no value", name)
+		default:
+			values[name] = fmt.Sprint(v)
+		}
+	}
+
+	return nil
+}
+
+// dateValue is a flag holding a date as YYYY-MM-DD
+type dateValue time.Time
+
+func (d *dateValue) String() string {
+	if d == nil || time.Time(*d).IsZero() {
+		return ""
+	}
+
+	return time.Time(*d).Format("2006-01-02")
+}
+
+func (d *dateValue) Set(s string) error {
+	if s == "" {
+		*d = dateValue(time.Time{})
+		return nil
+	}
+
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return errors.New("expected a date like 2027-04-30")
+	}
+	*d = dateValue(t)
+
+	return nil
+}
+
+// validate checks the settings that would otherwise fail late, or silently misbehave
+func (cfg config) validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(cfg.port > 0 && cfg.port < 65536, "port must be between 1 and 65535")
+	check(cfg.env == "development" || cfg.env == "production", "env must be development or production")
+	check(cfg.db.dsn != "", "db-dsn is required")
+	check(cfg.db.maxOpenConns >= 0 && cfg.db.maxIdleConns >= 0, "db connection limits cannot be negative")
+	check(cfg.db.maxOpenConns == 0 || cfg.db.maxIdleConns <= cfg.db.maxOpenConns, "db-max-idle-conns cannot be greater than db-max-open-conns")
+	check(cfg.db.connMaxLifetime >= 0 && cfg.db.connMaxIdleTime >= 0, "db connection lifetimes cannot be negative")
+	check(cfg.db.readTimeout > 0 && cfg.db.writeTimeout > 0 && cfg.db.bulkTimeout > 0, "db timeouts must be positive")
+	check(cfg.db.slowQuery >= 0, "db-slow-query cannot be negative")
+	check(cfg.images.dir != "", "images-dir is required")
+	check(cfg.images.gcInterval >= 0, "images-gc-interval cannot be negative")
+	check(cfg.images.dupThreshold >= 0 && cfg.images.dupThreshold <= 64, "images-dup-threshold must be between 0 and 64")
+	check(cfg.jwt.ttl > 0, "jwt-ttl must be positive")
+	check(cfg.cors.maxAge >= 0, "cors-max-age cannot be negative")
+	check(cfg.tracing.sampleRatio >= 0 && cfg.tracing.sampleRatio <= 1, "trace-sample-ratio must be between 0 and 1")
+	check(cfg.http.readTimeout > 0 && cfg.http.writeTimeout > 0 && cfg.http.idleTimeout > 0, "http timeouts must be positive")
+	check((cfg.tls.certFile == "") == (cfg.tls.keyFile == ""), "tls-cert and tls-key go together")
+	check(cfg.tlsEnabled() || (cfg.tls.clientCA == "" && cfg.tls.redirectPort == 0), "tls-client-ca and tls-redirect-port need tls-cert and tls-key")
+	check(cfg.tls.redirectPort >= 0 && cfg.tls.redirectPort < 65536 && cfg.tls.redirectPort != cfg.port, "tls-redirect-port must be a free port other than port")
+	check(cfg.tls.reloadInterval >= 0, "tls-reload-interval cannot be negative")
+	check(cfg.metrics.port >= 0 && cfg.metrics.port < 65536 && cfg.metrics.port != cfg.port, "metrics-port must be a free port other than port")
+	check(cfg.metrics.port == 0 || cfg.metrics.port != cfg.tls.redirectPort, "metrics-port and tls-redirect-port must be different")
+	check(cfg.idempotency.ttl <= 0 || cfg.idempotency.lease > 0, "idempotency-lease must be positive")
+	check(cfg.shutdownTimeout > 0, "shutdown-timeout must be positive")
+	check(cfg.shutdownDelay >= 0, "shutdown-delay cannot be negative")
+	if cfg.limiter.enabled {
+		check(cfg.limiter.rps > 0 && cfg.limiter.writeRPS > 0 && cfg.limiter.heavyRPS > 0 && cfg.limiter.authRPS > 0, "limiter rates must be positive")
+		check(cfg.limiter.burst > 0 && cfg.limiter.writeBurst > 0 && cfg.limiter.heavyBurst > 0 && cfg.limiter.authBurst > 0, "limiter bursts must be positive")
+	}
+	if cfg.env == "production" {
+		check(cfg.jwt.secret != "" || cfg.jwt.rsaKey != "", "jwt-secret or jwt-rsa-key is required in production")
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// dsnPassword matches the password of a key=value connection string
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('[^']*'|\S+)`)
+
+// redactSetting hides secrets and the password of connection strings
+func redactSetting(name, value string) string {
+	if value == "" {
+		return value
+	}
+	if secretFlags[name] {
+		return "REDACTED"
+	}
+
+	if name == "db-dsn" {
+		if u, err := url.Parse(value); err == nil && u.Scheme != "" {
+			if _, ok := u.User.Password(); ok {
+				u.User = url.UserPassword(u.User.Username(), "REDACTED")
+			}
+			if q := u.Query(); q.Get("password") != "" {
+				q.Set("password", "REDACTED")
+				u.RawQuery = q.Encode()
+			}
+			return u.String()
+		}
+		return dsnPassword.ReplaceAllString(value, "${1}REDACTED")
+	}
+
+	return value
+}
+
+// logConfig logs every setting, with secrets redacted
+func logConfig(logger *slog.Logger, fs *flag.FlagSet) {
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if _, alias := flagAliases[f.Name]; !alias {
+			names = append(names, f.Name)
+		}
+	})
+	sort.Strings(names)
+
+	attrs := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		attrs = append(attrs, slog.String(name, redactSetting(name, fs.Lookup(name).Value.String())))
+	}
+
+	logger.Info("configuration", attrs...)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gameETag is the entity tag of a game with version
+func gameETag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// etagMatches tells whether the list of entity tags of an If-Match or If-None-Match header contains
+// etag, or is "*". Weak tags only match with the weak comparison of If-None-Match
+func etagMatches(header, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkIfMatch checks the If-Match header of a write against the current version of the game.
+// It answers 428 if the header is required and missing, or 412 if it does not match, returning false
+func (app *application) checkIfMatch(w http.ResponseWriter, r *http.Request, version int) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		if app.config.http.requireIfMatch {
+			app.errorJSON(w, r, errors.New("the If-Match header with the ETag of the game is required"), http.StatusPreconditionRequired)
+			return false
+		}
+		return true
+	}
+
+	if !etagMatches(ifMatch, gameETag(version), false) {
+		w.Header().Set("ETag", gameETag(version))
+		app.errorJSON(w, r, errors.New("the game was modified since it was read"), http.StatusPreconditionFailed)
+		return false
+	}
+
+	return true
+}
+
+// notModified answers 304 if the If-None-Match header of a read matches etag, returning true
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if ifNoneMatch == "" || !etagMatches(ifNoneMatch, etag, true) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
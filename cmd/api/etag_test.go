@@ -0,0 +1,144 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGameETag(t *testing.T) {
+	tests := []struct {
+		version int
+		want    string
+	}{
+		{1, `"1"`},
+		{42, `"42"`},
+	}
+
+	for _, tt := range tests {
+		if got := gameETag(tt.version); got != tt.want {
+			t.Errorf("gameETag(%d) = %s, want %s", tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		etag   string
+		weak   bool
+		want   bool
+	}{
+		{name: "same tag", header: `"3"`, etag: `"3"`, want: true},
+		{name: "other tag", header: `"2"`, etag: `"3"`, want: false},
+		{name: "unquoted", header: `3`, etag: `"3"`, want: false},
+		{name: "any", header: `*`, etag: `"3"`, want: true},
+		{name: "list", header: `"1", "2","3"`, etag: `"3"`, want: true},
+		{name: "list without the tag", header: `"1", "2"`, etag: `"3"`, want: false},
+		{name: "any in a list", header: `"1", *`, etag: `"3"`, want: true},
+		{name: "weak tag, strong comparison", header: `W/"3"`, etag: `"3"`, want: false},
+		{name: "weak tag, weak comparison", header: `W/"3"`, etag: `"3"`, weak: true, want: true},
+		{name: "weak list, weak comparison", header: `W/"1", W/"3"`, etag: `"3"`, weak: true, want: true},
+		{name: "prefix of the tag", header: `"30"`, etag: `"3"`, want: false},
+		{name: "empty", header: ``, etag: `"3"`, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := etagMatches(tt.header, tt.etag, tt.weak); got != tt.want {
+				t.Errorf("etagMatches(%q, %q, %t) = %t, want %t", tt.header, tt.etag, tt.weak, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckIfMatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		ifMatch    string
+		required   bool
+		version    int
+		want       bool
+		wantStatus int
+		wantETag   string
+	}{
+		{name: "no header", version: 3, want: true},
+		{name: "no header when required", required: true, version: 3, wantStatus: http.StatusPreconditionRequired},
+		{name: "current version", ifMatch: `"3"`, version: 3, want: true},
+		{name: "current version when required", ifMatch: `"3"`, required: true, version: 3, want: true},
+		{name: "old version", ifMatch: `"2"`, version: 3, wantStatus: http.StatusPreconditionFailed, wantETag: `"3"`},
+		{name: "weak tag", ifMatch: `W/"3"`, version: 3, wantStatus: http.StatusPreconditionFailed, wantETag: `"3"`},
+		{name: "any version", ifMatch: `*`, version: 3, want: true},
+		{name: "one of several", ifMatch: `"2", "3"`, version: 3, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+			app.config.http.requireIfMatch = tt.required
+
+			r := httptest.NewRequest(http.MethodPut, "/v2/games/1", nil)
+			if tt.ifMatch != "" {
+				r.Header.Set("If-Match", tt.ifMatch)
+			}
+			rec := httptest.NewRecorder()
+
+			got := app.checkIfMatch(rec, r, tt.version)
+			if got != tt.want {
+				t.Fatalf("checkIfMatch = %t, want %t", got, tt.want)
+			}
+			if got {
+				if rec.Body.Len() != 0 {
+					t.Errorf("wrote %q to a request that goes on", rec.Body.String())
+				}
+				return
+			}
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if etag := rec.Header().Get("ETag"); etag != tt.wantETag {
+				t.Errorf("ETag = %q, want %q", etag, tt.wantETag)
+			}
+		})
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		etag        string
+		want        bool
+	}{
+		{name: "no header", etag: `"3"`, want: false},
+		{name: "same version", ifNoneMatch: `"3"`, etag: `"3"`, want: true},
+		{name: "weak tag", ifNoneMatch: `W/"3"`, etag: `"3"`, want: true},
+		{name: "old version", ifNoneMatch: `"2"`, etag: `"3"`, want: false},
+		{name: "any", ifNoneMatch: `*`, etag: `"3"`, want: true},
+		{name: "one of several", ifNoneMatch: `"1", W/"3"`, etag: `"3"`, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v2/games/1", nil)
+			if tt.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			rec := httptest.NewRecorder()
+
+			got := notModified(rec, r, tt.etag)
+			if got != tt.want {
+				t.Fatalf("notModified = %t, want %t", got, tt.want)
+			}
+			if got && rec.Code != http.StatusNotModified {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusNotModified)
+			}
+			if !got && rec.Body.Len() != 0 {
+				t.Errorf("wrote %q to a request that goes on", rec.Body.String())
+			}
+		})
+	}
+}
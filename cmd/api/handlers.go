@@ -4,11 +4,10 @@ import (
 	"CRUDWeb/models"
 	"encoding/json"
 	"errors"
-	"io"
-	"io/ioutil"
 	"net/http"
-	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
@@ -16,15 +15,11 @@ import (
 
 // statusHandler handles /status
 func (app *application) statusHandler(w http.ResponseWriter, r *http.Request) {
-	currentStatus := AppStatus{
-		Status:      "Available",
-		Environment: app.config.env,
-		Version:     version,
-	}
+	currentStatus := app.appStatus()
 
 	js, err := json.MarshalIndent(currentStatus, "", "\t")
 	if err != nil {
-		app.logger.Println(err)
+		app.log(r).Error("encoding status", "error", err)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -32,114 +27,95 @@ func (app *application) statusHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(js)
 }
 
-// getAllGenres handles /v1/genres
+// getAllGenres handles /v1/genres and /v2/genres
 func (app *application) getAllGenres(w http.ResponseWriter, r *http.Request) {
-	genres, err := app.models.DB.GetAllGenres()
+	genres, err := app.db(r).GetAllGenres(r.Context())
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, r, err)
 		return
 	}
 
 	app.writeJSON(w, http.StatusOK, genres, "genres")
 }
 
-// getAllModes handles /v1/modes
+// getAllModes handles /v1/modes and /v2/modes
 func (app *application) getAllModes(w http.ResponseWriter, r *http.Request) {
-	modes, err := app.models.DB.GetAllModes()
+	modes, err := app.db(r).GetAllModes(r.Context())
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, r, err)
 		return
 	}
 
 	app.writeJSON(w, http.StatusOK, modes, "modes")
 }
 
-// getAllGames handles /v1/games
+// getAllGames handles /v1/games and /v2/games
 func (app *application) getAllGames(w http.ResponseWriter, r *http.Request) {
-	games, err := app.models.DB.GetAllGames()
+	games, err := app.db(r).GetAllGames(r.Context())
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, r, err)
 		return
 	}
 	for _, game := range games {
 		game.ImageUrl = ""
+		setMediaUrls(r, game)
 	}
 
 	app.writeJSON(w, http.StatusOK, games, "games")
 }
 
-// getOneGame handles /v1/game/:id
+// getOneGame handles /v1/game/:id and /v2/games/:id
+// The game is sent with its ETag, and 304 is answered if If-None-Match has it
 func (app *application) getOneGame(w http.ResponseWriter, r *http.Request) {
 	params := httprouter.ParamsFromContext(r.Context())
 
 	id, err := strconv.Atoi(params.ByName("id"))
 	if err != nil {
-		app.logger.Print(errors.New("invalid id parameter"))
-		app.errorJSON(w, err)
+		app.log(r).Warn("invalid id parameter", "error", err)
+		app.errorJSON(w, r, err)
 		return
 	}
 
-	game, err := app.models.DB.GetOneGame(id)
+	game, err := app.db(r).GetOneGame(r.Context(), id)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", gameETag(game.Version))
+	if notModified(w, r, gameETag(game.Version)) {
 		return
 	}
+
 	game.ImageUrl = ""
+	setMediaUrls(r, game)
 
 	app.writeJSON(w, http.StatusOK, game, "game")
 }
 
-// getGameImage handles /v1/game/:id/image
+// getGameImage handles /v1/game/:id/image and /v2/games/:id/image
 func (app *application) getGameImage(w http.ResponseWriter, r *http.Request) {
 	params := httprouter.ParamsFromContext(r.Context())
 
 	id, err := strconv.Atoi(params.ByName("id"))
 	if err != nil {
-		app.logger.Print(errors.New("invalid id parameter"))
-		app.errorJSON(w, err)
+		app.log(r).Warn("invalid id parameter", "error", err)
+		app.errorJSON(w, r, err)
 		return
 	}
 
-	image, err := app.models.DB.GetGameImage(id)
+	image, err := app.db(r).GetGameImage(r.Context(), id)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, r, err)
 		return
 	}
-
-	// abrimos imagen y la devolvemos al cliente
-	file, err := ioutil.ReadFile("./images/" + image)
-	if err != nil {
-		app.logger.Print(err)
-		app.errorJSON(w, err)
-		return
-	}
-
-	w.Header().Set("Content-Type", "image/png")
-	w.WriteHeader(http.StatusOK)
-	w.Write(file)
-}
-
-// getAllImages handles /v1/game/images
-func (app *application) getAllImages(w http.ResponseWriter, r *http.Request) {
-
-	images, err := app.models.DB.GetAllImages()
-	if err != nil {
-		app.errorJSON(w, err)
+	if image == "" {
+		app.errorJSON(w, r, errors.New("game has no image"))
 		return
 	}
 
-	arrayImagesFile := make(map[int][]byte)
-	for id, imageUrl := range images {
-		file, err := ioutil.ReadFile("./images/" + imageUrl)
-		if err != nil {
-			app.logger.Print(err)
-			app.errorJSON(w, err)
-			return
-		}
-
-		arrayImagesFile[id] = file
-	}
-	app.writeJSON(w, http.StatusOK, arrayImagesFile, "images")
+	// abrimos imagen y la devolvemos al cliente
+	app.serveImage(w, r, image)
 }
 
 // getAllGames handles /v1/games/genre/:genre
@@ -148,23 +124,48 @@ func (app *application) getAllGamesByGenre(w http.ResponseWriter, r *http.Reques
 
 	genreID, err := strconv.Atoi(params.ByName("genre"))
 	if err != nil {
-		app.logger.Print(errors.New("invalid genre_id parameter"))
-		app.errorJSON(w, err)
+		app.log(r).Warn("invalid genre_id parameter", "error", err)
+		app.errorJSON(w, r, err)
 		return
 	}
 
-	games, err := app.models.DB.GetAllGamesByGenre(genreID)
+	games, err := app.db(r).GetAllGamesByGenre(r.Context(), genreID)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, r, err)
 		return
 	}
 	for _, game := range games {
 		game.ImageUrl = ""
+		setMediaUrls(r, game)
 	}
 
 	app.writeJSON(w, http.StatusOK, games, "games")
 }
 
+// payloadFromGame returns the payload that would update a game to its current values
+func payloadFromGame(game *models.Game) gamePayload {
+	payload := gamePayload{
+		Title:       game.Title,
+		Genres:      []int{},
+		Modes:       []int{},
+		Developers:  game.Developers,
+		Publishers:  game.Publishers,
+		ReleaseDate: game.ReleaseDate,
+		Storage:     game.Storage,
+		Likes:       game.Likes,
+	}
+	for id := range game.Genres {
+		payload.Genres = append(payload.Genres, id)
+	}
+	for id := range game.Modes {
+		payload.Modes = append(payload.Modes, id)
+	}
+	sort.Ints(payload.Genres)
+	sort.Ints(payload.Modes)
+
+	return payload
+}
+
 type gamePayload struct {
 	Title       string    `json:"title"`
 	Genres      []int     `json:"genres"`
@@ -176,34 +177,89 @@ type gamePayload struct {
 	Likes       int       `json:"likes"`
 }
 
-// insertGame handles /v1/games/insert
+// validate checks the payload describes a valid game, reporting every problem found
+func (p gamePayload) validate() error {
+	var problems []string
+
+	if strings.TrimSpace(p.Title) == "" {
+		problems = append(problems, "title must be provided")
+	} else if len(p.Title) > 200 {
+		problems = append(problems, "title must not be longer than 200 bytes")
+	}
+	if strings.ContainsAny(p.Title, `/\`) {
+		problems = append(problems, "title must not contain slashes")
+	}
+	for _, id := range p.Genres {
+		if id <= 0 {
+			problems = append(problems, "genres must be positive ids")
+			break
+		}
+	}
+	for _, id := range p.Modes {
+		if id <= 0 {
+			problems = append(problems, "modes must be positive ids")
+			break
+		}
+	}
+	if p.Storage < 0 {
+		problems = append(problems, "storage must not be negative")
+	}
+	if p.Likes < 0 {
+		problems = append(problems, "likes must not be negative")
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// insertGame handles /v1/games/insert and POST /v2/games.
+// The game comes either as an application/json body, without image, or as multipart form data with
+// the game JSON in the "game" field and its cover in "image"
 func (app *application) insertGame(w http.ResponseWriter, r *http.Request) {
 	var payload gamePayload
+	var cover *coverUpload
 
-	gameString := r.PostFormValue("game")
-	err := json.Unmarshal([]byte(gameString), &payload)
-	if err != nil {
-		app.errorJSON(w, err)
-		return
+	if isJSONRequest(r) {
+		// con un cuerpo JSON el juego se crea sin imagen, que se sube despues
+		err := app.readJSON(w, r, &payload)
+		if err != nil {
+			app.errorJSON(w, r, err)
+			return
+		}
+	} else {
+		gameString := r.PostFormValue("game")
+		err := json.Unmarshal([]byte(gameString), &payload)
+		if err != nil {
+			app.errorJSON(w, r, err)
+			return
+		}
+
+		// leer imagen y crear archivo imagen en carpeta del proyecto (servidor)
+		cover, err = app.readCover(r, 0)
+		if err != nil {
+			app.uploadError(w, r, err)
+			return
+		}
 	}
 
-	// leer imagen y crear archivo imagen en carpeta del proyecto (servidor)
-	r.ParseMultipartForm(32 << 20)
-	file, _, err := r.FormFile("image")
+	err := payload.validate()
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, r, err, http.StatusUnprocessableEntity)
 		return
 	}
-	defer file.Close()
 
-	imageName := payload.Title + ".png"
-	f, err := os.OpenFile("./images/"+imageName, os.O_WRONLY|os.O_CREATE, 0666)
-	if err != nil {
-		app.errorJSON(w, err)
-		return
+	var imageName string
+	if cover != nil {
+		imageName = coverName(payload.Title, cover)
+		err = app.saveCover(r.Context(), imageName, cover)
+		if err != nil {
+			app.errorJSON(w, r, err)
+			return
+		}
 	}
-	defer f.Close()
-	io.Copy(f, file)
 
 	var game models.Game
 	game.Title = payload.Title
@@ -214,59 +270,122 @@ func (app *application) insertGame(w http.ResponseWriter, r *http.Request) {
 	game.Storage = payload.Storage
 	game.Likes = 0
 
-	err = app.models.DB.InsertGame(&game, payload.Genres, payload.Modes)
+	err = app.db(r).InsertGame(r.Context(), &game, payload.Genres, payload.Modes)
 	if err != nil {
-		app.errorJSON(w, err)
+		if cover != nil {
+			app.images.Remove(r.Context(), imageName)
+		}
+		app.errorJSON(w, r, err)
 		return
 	}
 
+	var duplicates []int
+	if cover != nil {
+		err = app.saveCoverHash(w, r, game.ID, cover)
+		if err != nil {
+			app.errorJSON(w, r, err)
+			return
+		}
+		duplicates = cover.duplicates
+	}
+
 	type jsonResp struct {
-		OK bool `json:"ok"`
+		OK         bool  `json:"ok"`
+		Duplicates []int `json:"duplicates,omitempty"`
+	}
+
+	if isV2(r) {
+		created, err := app.db(r).GetOneGame(r.Context(), game.ID)
+		if err != nil {
+			app.errorJSON(w, r, err)
+			return
+		}
+		created.ImageUrl = ""
+		setMediaUrls(r, created)
+		w.Header().Set("ETag", gameETag(created.Version))
+
+		app.writeCreated(w, r, gamePath(r, game.ID), created, "game")
+		return
 	}
 
 	ok := jsonResp{
-		OK: true,
+		OK:         true,
+		Duplicates: duplicates,
 	}
 	app.writeJSON(w, http.StatusOK, ok, "OK")
 }
 
-// updateGame handles /v1/games/update/:id
+// updateGame handles /v1/games/update/:id and PUT /v2/games/:id, replacing every field of the game.
+// Like insertGame it takes an application/json body or multipart form data, where the image is optional.
+// If-Match, when sent, must have the ETag of the game; http-require-if-match makes it mandatory
 func (app *application) updateGame(w http.ResponseWriter, r *http.Request) {
 	params := httprouter.ParamsFromContext(r.Context())
 
 	id, err := strconv.Atoi(params.ByName("id"))
 	if err != nil {
-		app.logger.Print(errors.New("invalid id parameter"))
-		app.errorJSON(w, err)
+		app.log(r).Warn("invalid id parameter", "error", err)
+		app.errorJSON(w, r, err)
 		return
 	}
 
-	var payload gamePayload
-
-	gameString := r.PostFormValue("game")
-	err = json.Unmarshal([]byte(gameString), &payload)
+	version, err := app.db(r).GetGameVersion(r.Context(), id)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, r, err)
+		return
+	}
+	if !app.checkIfMatch(w, r, version) {
 		return
 	}
 
-	// leer imagen y crear archivo imagen en carpeta del proyecto (servidor)
-	r.ParseMultipartForm(32 << 20)
-	file, _, err := r.FormFile("image")
+	var payload gamePayload
+	var cover *coverUpload
+
+	if isJSONRequest(r) {
+		err = app.readJSON(w, r, &payload)
+		if err != nil {
+			app.errorJSON(w, r, err)
+			return
+		}
+	} else {
+		gameString := r.PostFormValue("game")
+		err = json.Unmarshal([]byte(gameString), &payload)
+		if err != nil {
+			app.errorJSON(w, r, err)
+			return
+		}
+
+		cover, err = app.readCover(r, id)
+		if errors.Is(err, http.ErrMissingFile) {
+			cover, err = nil, nil
+		}
+		if err != nil {
+			app.uploadError(w, r, err)
+			return
+		}
+	}
+
+	err = payload.validate()
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, r, err, http.StatusUnprocessableEntity)
 		return
 	}
-	defer file.Close()
 
-	imageName := payload.Title + ".png"
-	f, err := os.OpenFile("./images/"+imageName, os.O_WRONLY|os.O_CREATE, 0666)
+	// la imagen es opcional: si no se envia, el juego conserva la que tenia
+	previousImage, err := app.db(r).GetGameImage(r.Context(), id)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, r, err)
 		return
 	}
-	defer f.Close()
-	io.Copy(f, file)
+
+	imageName := previousImage
+	if cover != nil {
+		imageName = coverName(payload.Title, cover)
+		err = app.saveCover(r.Context(), imageName, cover)
+		if err != nil {
+			app.errorJSON(w, r, err)
+			return
+		}
+	}
 
 	var game models.Game
 	game.Title = payload.Title
@@ -275,38 +394,76 @@ func (app *application) updateGame(w http.ResponseWriter, r *http.Request) {
 	game.Publishers = payload.Publishers
 	game.ReleaseDate = payload.ReleaseDate
 	game.Storage = payload.Storage
-	game.Likes = 0
+	game.Likes = payload.Likes
+	game.Version = version
 
-	err = app.models.DB.UpdateGame(id, &game, payload.Genres, payload.Modes)
+	err = app.db(r).UpdateGame(r.Context(), id, &game, payload.Genres, payload.Modes)
+	if err != nil && cover != nil {
+		// el juego sigue apuntando a su portada anterior, que no se ha tocado
+		app.images.Remove(r.Context(), imageName)
+	}
+	if errors.Is(err, models.ErrEditConflict) {
+		app.errorJSON(w, r, err, http.StatusPreconditionFailed)
+		return
+	}
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, r, err)
 		return
 	}
+	w.Header().Set("ETag", gameETag(game.Version))
+
+	var duplicates []int
+	if cover != nil {
+		app.removeUnusedCover(r, previousImage)
+
+		err = app.saveCoverHash(w, r, id, cover)
+		if err != nil {
+			app.errorJSON(w, r, err)
+			return
+		}
+		duplicates = cover.duplicates
+	}
 
 	type jsonResp struct {
-		OK bool `json:"ok"`
+		OK         bool  `json:"ok"`
+		Duplicates []int `json:"duplicates,omitempty"`
 	}
 
 	ok := jsonResp{
-		OK: true,
+		OK:         true,
+		Duplicates: duplicates,
 	}
-	app.writeJSON(w, http.StatusOK, ok, "OK")
+	app.writeOK(w, r, ok)
 }
 
-// deleteGame handles /v1/games/delete
+// deleteGame handles /v1/games/delete/:id and DELETE /v2/games/:id, with the If-Match rules of updateGame.
+// The game goes to the trash, from where it can be restored until it is purged
 func (app *application) deleteGame(w http.ResponseWriter, r *http.Request) {
 	params := httprouter.ParamsFromContext(r.Context())
 
 	id, err := strconv.Atoi(params.ByName("id"))
 	if err != nil {
-		app.logger.Print(errors.New("invalid id parameter"))
-		app.errorJSON(w, err)
+		app.log(r).Warn("invalid id parameter", "error", err)
+		app.errorJSON(w, r, err)
 		return
 	}
 
-	err = app.models.DB.DeleteGame(id)
+	version, err := app.db(r).GetGameVersion(r.Context(), id)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, r, err)
+		return
+	}
+	if !app.checkIfMatch(w, r, version) {
+		return
+	}
+
+	err = app.db(r).DeleteGame(r.Context(), id, version)
+	if errors.Is(err, models.ErrEditConflict) {
+		app.errorJSON(w, r, err, http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, r, err)
 		return
 	}
 
@@ -317,5 +474,5 @@ func (app *application) deleteGame(w http.ResponseWriter, r *http.Request) {
 	ok := jsonResp{
 		OK: true,
 	}
-	app.writeJSON(w, http.StatusOK, ok, "OK")
+	app.writeOK(w, r, ok)
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// readinessTimeout bounds each check of /readyz, probes usually give up after a second or two
+const readinessTimeout = 2 * time.Second
+
+// healthz handles /healthz
+// liveness: the process is up and serving requests, it does not look at dependencies
+func (app *application) healthz(w http.ResponseWriter, r *http.Request) {
+	app.writeJSON(w, http.StatusOK, "ok", "status")
+}
+
+// readyz handles /readyz
+// readiness: the database answers and the image store is writable. It fails while shutting down
+func (app *application) readyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{}
+	ready := true
+
+	if atomic.LoadInt32(&app.shuttingDown) == 1 {
+		checks["server"] = "shutting down"
+		ready = false
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	checks["database"] = "ok"
+	err := app.models.DB.DB.PingContext(ctx)
+	if err != nil {
+		app.log(r).Warn("readiness: database", "error", err)
+		checks["database"] = "unavailable"
+		ready = false
+	}
+
+	checks["images"] = "ok"
+	err = app.images.Writable(ctx)
+	if err != nil {
+		app.log(r).Warn("readiness: image store", "error", err)
+		checks["images"] = "unavailable"
+		ready = false
+	}
+
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+
+	app.writeJSON(w, status, checks, "checks")
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"CRUDWeb/models"
+	"database/sql"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadyz(t *testing.T) {
+	// nadie escucha en el puerto 1: el ping falla enseguida
+	db, err := sql.Open("postgres", "postgres://test@127.0.0.1:1/test?sslmode=disable&connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	dir := filepath.Join(t.TempDir(), "missing")
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	app := &application{
+		logger: logger,
+		models: models.NewModels(db, logger),
+		images: &imageStore{dir: dir},
+	}
+
+	rec := httptest.NewRecorder()
+	app.readyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{`"database":"unavailable"`, `"images":"unavailable"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body %s does not have %s", body, want)
+		}
+	}
+	// las causas van al log, no a un endpoint anonimo
+	for _, leak := range []string{"127.0.0.1", dir} {
+		if strings.Contains(body, leak) {
+			t.Errorf("body %s reveals %s", body, leak)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"CRUDWeb/models"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// maxIdempotentBody is the biggest body of a request with Idempotency-Key, which is read in memory to hash it
+const maxIdempotentBody = maxImageSize + maxJSONBodySize
+
+// replayedHeaders are the response headers set by the handlers that are stored to be replayed.
+// The ones of the middleware (request id, rate limit, cors...) are set again on every request
+var replayedHeaders = []string{"Content-Type", "Location", "ETag", "Warning"}
+
+// idempotencyStore keeps the Idempotency-Key of each client with the response of its request
+type idempotencyStore interface {
+	ReserveIdempotencyKey(ctx context.Context, client, key, requestHash string, ttl, lease time.Duration) (*models.IdempotencyKey, error)
+	SaveIdempotentResponse(ctx context.Context, client, key string, status int, headers map[string][]string, body []byte) error
+	ReleaseIdempotencyKey(ctx context.Context, client, key string) error
+}
+
+// idempotencyKeyStore returns the store of the keys for the request r
+func (app *application) idempotencyKeyStore(r *http.Request) idempotencyStore {
+	if app.idempotencyKeys != nil {
+		return app.idempotencyKeys
+	}
+
+	return app.db(r)
+}
+
+// idempotencyRecorder passes the response through while keeping a copy to store it
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the original writer
+func (rec *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// idempotencyClient identifies the client that owns the keys of a request: its api key or its user.
+// Keys are not kept for anonymous requests, which cannot write anyway
+func idempotencyClient(r *http.Request) string {
+	p := principalFromContext(r.Context())
+	if p == nil {
+		return ""
+	}
+	if p.APIKeyID != 0 {
+		return fmt.Sprintf("key:%d", p.APIKeyID)
+	}
+	if p.UserID != 0 {
+		return fmt.Sprintf("user:%d", p.UserID)
+	}
+
+	return "sub:" + p.Subject
+}
+
+// idempotencyRequestHash identifies the request a key was used for by its method, uri and body
+func idempotencyRequestHash(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", r.Method, r.URL.RequestURI())
+	hash.Write(body)
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// idempotency makes the writes with an Idempotency-Key header safe to retry. The first request with a key
+// runs and its response is stored for the idempotency-ttl; retries with the same key get that response again,
+// with Idempotent-Replayed: true. A key reused with another method, path or body gets 422, and one whose
+// first request is still running gets 409, for up to the idempotency-lease. Responses 5xx are not stored,
+// so the request can be retried
+func (app *application) idempotency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || app.config.idempotency.ttl <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client := idempotencyClient(r)
+		if client == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > 255 {
+			app.errorJSON(w, r, errors.New("Idempotency-Key must not be longer than 255 characters"))
+			return
+		}
+
+		// el cuerpo se lee entero para calcular el hash y se devuelve a la peticion
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBody))
+		if err != nil {
+			app.errorJSON(w, r, fmt.Errorf("body must not be larger than %d bytes", maxIdempotentBody), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		requestHash := idempotencyRequestHash(r, body)
+
+		existing, err := app.idempotencyKeyStore(r).ReserveIdempotencyKey(r.Context(), client, key, requestHash, app.config.idempotency.ttl, app.config.idempotency.lease)
+		if err != nil {
+			app.errorJSON(w, r, err, http.StatusInternalServerError)
+			return
+		}
+
+		if existing != nil {
+			switch {
+			case existing.RequestHash != requestHash:
+				app.errorJSON(w, r, errors.New("Idempotency-Key was already used with a different request"), http.StatusUnprocessableEntity)
+			case existing.Status == 0:
+				w.Header().Set("Retry-After", "1")
+				app.errorJSON(w, r, errors.New("a request with this Idempotency-Key is still being processed"), http.StatusConflict)
+			default:
+				app.log(r).Info("replaying idempotent response", "idempotency_key", key, "status", existing.Status)
+				for name, values := range existing.Headers {
+					w.Header()[name] = values
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(existing.Status)
+				w.Write(existing.Body)
+			}
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: w}
+
+		// si el handler entra en panico la clave se libera para poder reintentar
+		completed := false
+		defer func() {
+			if !completed {
+				app.releaseIdempotencyKey(r, client, key)
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+		completed = true
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		if rec.status >= http.StatusInternalServerError {
+			app.releaseIdempotencyKey(r, client, key)
+			return
+		}
+
+		headers := make(map[string][]string)
+		for _, name := range replayedHeaders {
+			if values := rec.Header().Values(name); len(values) > 0 {
+				headers[name] = values
+			}
+		}
+
+		// la respuesta ya se ha enviado: se guarda aunque el cliente haya cortado la conexion
+		ctx := context.WithoutCancel(r.Context())
+		err = app.idempotencyKeyStore(r).SaveIdempotentResponse(ctx, client, key, rec.status, headers, rec.body.Bytes())
+		if err != nil {
+			app.log(r).Error("storing idempotent response", "idempotency_key", key, "error", err)
+		}
+	})
+}
+
+// releaseIdempotencyKey deletes the key of a request that failed
+func (app *application) releaseIdempotencyKey(r *http.Request, client, key string) {
+	err := app.idempotencyKeyStore(r).ReleaseIdempotencyKey(context.WithoutCancel(r.Context()), client, key)
+	if err != nil {
+		app.log(r).Error("releasing idempotency key", "idempotency_key", key, "error", err)
+	}
+}
+
+// idempotencyCleanupJob deletes the expired idempotency keys every interval, until ctx is done
+func (app *application) idempotencyCleanupJob(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		spanCtx, span := app.tracer.Start(context.Background(), "idempotency keys cleanup")
+		deleted, err := app.models.DB.DeleteExpiredIdempotencyKeys(spanCtx)
+		endSpan(span, err)
+		if err != nil {
+			app.logger.Error("idempotency keys cleanup", "error", err)
+			continue
+		}
+
+		if deleted > 0 {
+			app.logger.Info("idempotency keys cleanup", "deleted", deleted)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"CRUDWeb/models"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryIdempotencyStore keeps the keys in memory, without expiry
+type memoryIdempotencyStore struct {
+	mu       sync.Mutex
+	keys     map[string]*models.IdempotencyKey
+	saved    int
+	released int
+	// err, if set, is returned by ReserveIdempotencyKey
+	err error
+}
+
+func newMemoryIdempotencyStore() *memoryIdempotencyStore {
+	return &memoryIdempotencyStore{keys: make(map[string]*models.IdempotencyKey)}
+}
+
+func (s *memoryIdempotencyStore) ReserveIdempotencyKey(ctx context.Context, client, key, requestHash string, ttl, lease time.Duration) (*models.IdempotencyKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return nil, s.err
+	}
+	if existing, ok := s.keys[client+"/"+key]; ok {
+		copied := *existing
+		return &copied, nil
+	}
+
+	s.keys[client+"/"+key] = &models.IdempotencyKey{Client: client, Key: key, RequestHash: requestHash}
+	return nil, nil
+}
+
+func (s *memoryIdempotencyStore) SaveIdempotentResponse(ctx context.Context, client, key string, status int, headers map[string][]string, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.saved++
+	stored := s.keys[client+"/"+key]
+	stored.Status = status
+	stored.Headers = headers
+	stored.Body = body
+	return nil
+}
+
+func (s *memoryIdempotencyStore) ReleaseIdempotencyKey(ctx context.Context, client, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.released++
+	delete(s.keys, client+"/"+key)
+	return nil
+}
+
+func TestIdempotencyClient(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal *principal
+		want      string
+	}{
+		{name: "anonymous", want: ""},
+		{name: "api key", principal: &principal{Subject: "ci", UserID: 7, APIKeyID: 3}, want: "key:3"},
+		{name: "user", principal: &principal{Subject: "ana", UserID: 7}, want: "user:7"},
+		{name: "token without user", principal: &principal{Subject: "legacy"}, want: "sub:legacy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v2/games", nil)
+			if tt.principal != nil {
+				r = withPrincipal(r, tt.principal)
+			}
+
+			if got := idempotencyClient(r); got != tt.want {
+				t.Errorf("client = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIdempotency(t *testing.T) {
+	// created responde 201 con el cuerpo recibido, como un alta
+	type handlerFunc func(w http.ResponseWriter, r *http.Request)
+	created := func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/v2/games/1")
+		w.Header().Set("X-Not-Replayed", "1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(body)
+	}
+	failed := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	panics := func(w http.ResponseWriter, r *http.Request) {
+		panic("handler failed")
+	}
+
+	type step struct {
+		method       string
+		key          string
+		body         string
+		anonymous    bool
+		wantStatus   int
+		wantBody     string
+		wantReplayed bool
+		wantRetry    bool
+		wantPanic    bool
+	}
+
+	longKey := strings.Repeat("k", 256)
+
+	tests := []struct {
+		name         string
+		handler      handlerFunc
+		ttl          time.Duration
+		storeErr     error
+		pending      bool
+		steps        []step
+		wantCalls    int
+		wantSaved    int
+		wantReleased int
+	}{
+		{
+			name:    "without key",
+			handler: created,
+			steps: []step{
+				{method: http.MethodPost, body: `{"a":1}`, wantStatus: http.StatusCreated, wantBody: `{"a":1}`},
+				{method: http.MethodPost, body: `{"a":1}`, wantStatus: http.StatusCreated, wantBody: `{"a":1}`},
+			},
+			wantCalls: 2,
+		},
+		{
+			name:    "replayed",
+			handler: created,
+			steps: []step{
+				{method: http.MethodPost, key: "k1", body: `{"a":1}`, wantStatus: http.StatusCreated, wantBody: `{"a":1}`},
+				{method: http.MethodPost, key: "k1", body: `{"a":1}`, wantStatus: http.StatusCreated, wantBody: `{"a":1}`, wantReplayed: true},
+				{method: http.MethodPost, key: "k1", body: `{"a":1}`, wantStatus: http.StatusCreated, wantBody: `{"a":1}`, wantReplayed: true},
+			},
+			wantCalls: 1,
+			wantSaved: 1,
+		},
+		{
+			name:    "other keys run",
+			handler: created,
+			steps: []step{
+				{method: http.MethodPost, key: "k1", body: `{"a":1}`, wantStatus: http.StatusCreated, wantBody: `{"a":1}`},
+				{method: http.MethodPost, key: "k2", body: `{"a":1}`, wantStatus: http.StatusCreated, wantBody: `{"a":1}`},
+			},
+			wantCalls: 2,
+			wantSaved: 2,
+		},
+		{
+			name:    "key reused with another body",
+			handler: created,
+			steps: []step{
+				{method: http.MethodPost, key: "k1", body: `{"a":1}`, wantStatus: http.StatusCreated, wantBody: `{"a":1}`},
+				{method: http.MethodPost, key: "k1", body: `{"a":2}`, wantStatus: http.StatusUnprocessableEntity},
+			},
+			wantCalls: 1,
+			wantSaved: 1,
+		},
+		{
+			name:    "key reused with another method",
+			handler: created,
+			steps: []step{
+				{method: http.MethodPost, key: "k1", body: `{"a":1}`, wantStatus: http.StatusCreated, wantBody: `{"a":1}`},
+				{method: http.MethodPut, key: "k1", body: `{"a":1}`, wantStatus: http.StatusUnprocessableEntity},
+			},
+			wantCalls: 1,
+			wantSaved: 1,
+		},
+		{
+			name:    "request still running",
+			handler: created,
+			pending: true,
+			steps: []step{
+				{method: http.MethodPost, key: "k1", body: `{"a":1}`, wantStatus: http.StatusConflict, wantRetry: true},
+			},
+		},
+		{
+			name:    "reads are not tracked",
+			handler: created,
+			steps: []step{
+				{method: http.MethodGet, key: "k1", wantStatus: http.StatusCreated},
+				{method: http.MethodGet, key: "k1", wantStatus: http.StatusCreated},
+			},
+			wantCalls: 2,
+		},
+		{
+			name:    "anonymous requests are not tracked",
+			handler: created,
+			steps: []step{
+				{method: http.MethodPost, key: "k1", body: `{"a":1}`, anonymous: true, wantStatus: http.StatusCreated, wantBody: `{"a":1}`},
+				{method: http.MethodPost, key: "k1", body: `{"a":1}`, anonymous: true, wantStatus: http.StatusCreated, wantBody: `{"a":1}`},
+			},
+			wantCalls: 2,
+		},
+		{
+			name:    "disabled",
+			handler: created,
+			ttl:     -1,
+			steps: []step{
+				{method: http.MethodPost, key: "k1", body: `{"a":1}`, wantStatus: http.StatusCreated, wantBody: `{"a":1}`},
+				{method: http.MethodPost, key: "k1", body: `{"a":1}`, wantStatus: http.StatusCreated, wantBody: `{"a":1}`},
+			},
+			wantCalls: 2,
+		},
+		{
+			name:    "key too long",
+			handler: created,
+			steps: []step{
+				{method: http.MethodPost, key: longKey, body: `{"a":1}`, wantStatus: http.StatusBadRequest},
+			},
+		},
+		{
+			name:    "server errors are retried",
+			handler: failed,
+			steps: []step{
+				{method: http.MethodPost, key: "k1", body: `{"a":1}`, wantStatus: http.StatusServiceUnavailable},
+				{method: http.MethodPost, key: "k1", body: `{"a":1}`, wantStatus: http.StatusServiceUnavailable},
+			},
+			wantCalls:    2,
+			wantReleased: 2,
+		},
+		{
+			name:    "panics release the key",
+			handler: panics,
+			steps: []step{
+				{method: http.MethodPost, key: "k1", body: `{"a":1}`, wantPanic: true},
+				{method: http.MethodPost, key: "k1", body: `{"a":1}`, wantPanic: true},
+			},
+			wantCalls:    2,
+			wantReleased: 2,
+		},
+		{
+			name:     "store down",
+			handler:  created,
+			storeErr: errors.New("store is down"),
+			steps: []step{
+				{method: http.MethodPost, key: "k1", body: `{"a":1}`, wantStatus: http.StatusInternalServerError},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemoryIdempotencyStore()
+			store.err = tt.storeErr
+			if tt.pending {
+				first := httptest.NewRequest(tt.steps[0].method, "/v2/games", nil)
+				hash := idempotencyRequestHash(first, []byte(tt.steps[0].body))
+				store.keys["user:1/k1"] = &models.IdempotencyKey{Client: "user:1", Key: "k1", RequestHash: hash}
+			}
+
+			app := &application{
+				logger:          slog.New(slog.NewTextHandler(io.Discard, nil)),
+				idempotencyKeys: store,
+			}
+			app.config.idempotency.ttl = time.Hour
+			if tt.ttl != 0 {
+				app.config.idempotency.ttl = tt.ttl
+			}
+			app.config.idempotency.lease = time.Minute
+
+			calls := 0
+			handler := app.idempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				tt.handler(w, r)
+			}))
+
+			for i, s := range tt.steps {
+				r := httptest.NewRequest(s.method, "/v2/games", strings.NewReader(s.body))
+				if s.key != "" {
+					r.Header.Set("Idempotency-Key", s.key)
+				}
+				if !s.anonymous {
+					r = withPrincipal(r, &principal{Subject: "ana", UserID: 1, Role: "editor"})
+				}
+				rec := httptest.NewRecorder()
+
+				panicked := func() (panicked bool) {
+					defer func() {
+						panicked = recover() != nil
+					}()
+					handler.ServeHTTP(rec, r)
+					return false
+				}()
+				if panicked != s.wantPanic {
+					t.Fatalf("step %d: panicked = %t, want %t", i, panicked, s.wantPanic)
+				}
+				if s.wantPanic {
+					continue
+				}
+
+				if rec.Code != s.wantStatus {
+					t.Errorf("step %d: status = %d, want %d", i, rec.Code, s.wantStatus)
+				}
+				if s.wantBody != "" && rec.Body.String() != s.wantBody {
+					t.Errorf("step %d: body = %q, want %q", i, rec.Body.String(), s.wantBody)
+				}
+				if replayed := rec.Header().Get("Idempotent-Replayed") == "true"; replayed != s.wantReplayed {
+					t.Errorf("step %d: replayed = %t, want %t", i, replayed, s.wantReplayed)
+				}
+				if s.wantReplayed {
+					if rec.Header().Get("Location") != "/v2/games/1" || rec.Header().Get("X-Not-Replayed") != "" {
+						t.Errorf("step %d: replayed headers %v, want only the ones of the handler", i, rec.Header())
+					}
+				}
+				if retry := rec.Header().Get("Retry-After") != ""; retry != s.wantRetry {
+					t.Errorf("step %d: Retry-After = %q", i, rec.Header().Get("Retry-After"))
+				}
+			}
+
+			if calls != tt.wantCalls {
+				t.Errorf("handler ran %d times, want %d", calls, tt.wantCalls)
+			}
+			if store.saved != tt.wantSaved {
+				t.Errorf("saved %d responses, want %d", store.saved, tt.wantSaved)
+			}
+			if store.released != tt.wantReleased {
+				t.Errorf("released %d keys, want %d", store.released, tt.wantReleased)
+			}
+		})
+	}
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"CRUDWeb/models"
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// imageStore is the folder on the server where game images are kept
+type imageStore struct {
+	dir    string
+	tracer trace.Tracer
+}
+
+// startSpan starts the span of an operation of the store on the image name
+func (s *imageStore) startSpan(ctx context.Context, op, name string) (context.Context, trace.Span) {
+	tracer := s.tracer
+	if tracer == nil {
+		tracer = otel.Tracer(tracerName)
+	}
+
+	ctx, span := tracer.Start(ctx, "images."+op)
+	if name != "" {
+		span.SetAttributes(attribute.String("image.file", filepath.Base(name)))
+	}
+
+	return ctx, span
+}
+
+// path returns the location of an image inside the store, ignoring any directory part of name
+func (s *imageStore) path(name string) string {
+	return filepath.Join(s.dir, filepath.Base(name))
+}
+
+// Open opens an image for reading
+func (s *imageStore) Open(ctx context.Context, name string) (*os.File, error) {
+	_, span := s.startSpan(ctx, "Open", name)
+	f, err := os.Open(s.path(name))
+	endSpan(span, err)
+
+	return f, err
+}
+
+// Stat returns the file info of an image
+func (s *imageStore) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	_, span := s.startSpan(ctx, "Stat", name)
+	info, err := os.Stat(s.path(name))
+	endSpan(span, err)
+
+	return info, err
+}
+
+// List returns the regular files of the store, skipping hidden ones
+func (s *imageStore) List(ctx context.Context) (files []os.FileInfo, err error) {
+	_, span := s.startSpan(ctx, "List", "")
+	defer func() { endSpan(span, err) }()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, info)
+	}
+	span.SetAttributes(attribute.Int("image.count", len(files)))
+
+	return files, nil
+}
+
+// Save writes src to the store as name, replacing any previous image with that name
+func (s *imageStore) Save(ctx context.Context, name string, src io.Reader) (err error) {
+	_, span := s.startSpan(ctx, "Save", name)
+	defer func() { endSpan(span, err) }()
+
+	f, err := os.Create(s.path(name))
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(f, src)
+	span.SetAttributes(attribute.Int64("image.bytes", n))
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// Remove deletes an image from the store. Removing an image that does not exist is not an error
+func (s *imageStore) Remove(ctx context.Context, name string) (err error) {
+	_, span := s.startSpan(ctx, "Remove", name)
+	defer func() { endSpan(span, err) }()
+
+	err = os.Remove(s.path(name))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// Writable checks that files can be created in the store by writing and removing a hidden temporary file
+func (s *imageStore) Writable(ctx context.Context) (err error) {
+	_, span := s.startSpan(ctx, "Writable", "")
+	defer func() { endSpan(span, err) }()
+
+	f, err := os.CreateTemp(s.dir, ".writable-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+
+	return os.Remove(f.Name())
+}
+
+// imageManifestEntry describes one cover in the images manifest
+type imageManifestEntry struct {
+	ID       int    `json:"id"`
+	ImageUrl string `json:"image_url"`
+	File     string `json:"file"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256,omitempty"`
+	Missing  bool   `json:"missing,omitempty"`
+}
+
+// serveImage writes an image from the store to the client
+func (app *application) serveImage(w http.ResponseWriter, r *http.Request, name string) {
+	file, err := app.images.Open(r.Context(), name)
+	if err != nil {
+		app.log(r).Error("opening image", "error", err, "file", name)
+		app.errorJSON(w, r, err)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		app.log(r).Error("reading image info", "error", err, "file", name)
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	rec := &statusRecorder{ResponseWriter: w}
+	http.ServeContent(rec, r, info.Name(), info.ModTime(), file)
+	app.metrics.imageBytes.Add(float64(rec.bytes))
+}
+
+// filteredImages returns the game images, restricted to the ids in the "ids" query parameter, if any.
+// Games without a cover are left out
+func (app *application) filteredImages(r *http.Request) (map[int]string, error) {
+	all, err := app.db(r).GetAllImages(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	images := make(map[int]string)
+	for id, image := range all {
+		if image != "" {
+			images[id] = image
+		}
+	}
+
+	idsParam := r.URL.Query().Get("ids")
+	if idsParam == "" {
+		return images, nil
+	}
+
+	filtered := make(map[int]string)
+	for _, s := range strings.Split(idsParam, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		if image, ok := images[id]; ok {
+			filtered[id] = image
+		}
+	}
+
+	return filtered, nil
+}
+
+// sortedIDs returns the keys of images in ascending order
+func sortedIDs(images map[int]string) []int {
+	ids := make([]int, 0, len(images))
+	for id := range images {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	return ids
+}
+
+// getAllImages handles /v1/games/images and /v2/images
+// streams every cover (or the ones in ?ids=) as a zip archive, or tar with ?format=tar.
+// Each entry is named after the game id, and missing files are skipped instead of failing the whole download
+func (app *application) getAllImages(w http.ResponseWriter, r *http.Request) {
+	images, err := app.filteredImages(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", "zip":
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="images.zip"`)
+		w.WriteHeader(http.StatusOK)
+
+		zw := zip.NewWriter(w)
+		for _, id := range sortedIDs(images) {
+			app.writeImageEntry(r, images[id], func(info os.FileInfo) (io.Writer, error) {
+				header, err := zip.FileInfoHeader(info)
+				if err != nil {
+					return nil, err
+				}
+				header.Name = strconv.Itoa(id) + filepath.Ext(images[id])
+				// las imagenes ya van comprimidas
+				header.Method = zip.Store
+				return zw.CreateHeader(header)
+			})
+		}
+		if err := zw.Close(); err != nil {
+			app.log(r).Error("closing images archive", "error", err)
+		}
+	case "tar":
+		w.Header().Set("Content-Type", "application/x-tar")
+		w.Header().Set("Content-Disposition", `attachment; filename="images.tar"`)
+		w.WriteHeader(http.StatusOK)
+
+		tw := tar.NewWriter(w)
+		for _, id := range sortedIDs(images) {
+			app.writeImageEntry(r, images[id], func(info os.FileInfo) (io.Writer, error) {
+				header, err := tar.FileInfoHeader(info, "")
+				if err != nil {
+					return nil, err
+				}
+				header.Name = strconv.Itoa(id) + filepath.Ext(images[id])
+				return tw, tw.WriteHeader(header)
+			})
+		}
+		if err := tw.Close(); err != nil {
+			app.log(r).Error("closing images archive", "error", err)
+		}
+	default:
+		app.errorJSON(w, r, errors.New("invalid format parameter"))
+	}
+}
+
+// writeImageEntry copies one image into an archive entry created by newEntry.
+// The response is already streaming, so errors can only be logged
+func (app *application) writeImageEntry(r *http.Request, name string, newEntry func(info os.FileInfo) (io.Writer, error)) {
+	file, err := app.images.Open(r.Context(), name)
+	if err != nil {
+		app.log(r).Warn("skipping image in archive", "error", err, "file", name)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		app.log(r).Warn("skipping image in archive", "error", err, "file", name)
+		return
+	}
+
+	entry, err := newEntry(info)
+	if err != nil {
+		app.log(r).Error("adding image to archive", "error", err, "file", name)
+		return
+	}
+
+	n, err := io.Copy(entry, file)
+	app.metrics.imageBytes.Add(float64(n))
+	if err != nil {
+		app.log(r).Error("adding image to archive", "error", err, "file", name)
+	}
+}
+
+// getImagesManifest handles /v1/games/images/manifest and /v2/images/manifest
+// uses the checksums stored at upload, and only hashes the covers uploaded before they were stored
+func (app *application) getImagesManifest(w http.ResponseWriter, r *http.Request) {
+	images, err := app.filteredImages(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	checksums, err := app.db(r).GetAllImageChecksums(r.Context())
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	manifest := make([]imageManifestEntry, 0, len(images))
+	for _, id := range sortedIDs(images) {
+		entry := imageManifestEntry{
+			ID:       id,
+			ImageUrl: gamePath(r, id) + "/image",
+			File:     images[id],
+		}
+
+		// cada entrada tiene su propio error: un fallo no marca las siguientes como perdidas
+		var err error
+		checksum, ok := checksums[id]
+		if !ok || checksum.File != images[id] {
+			checksum, err = app.backfillChecksum(r, id, images[id])
+		}
+		if err != nil {
+			app.log(r).Warn("hashing image", "error", err, "file", images[id])
+			entry.Missing = true
+		} else {
+			entry.SHA256 = checksum.SHA256
+			entry.Size = checksum.Size
+		}
+
+		manifest = append(manifest, entry)
+	}
+
+	app.writeJSON(w, http.StatusOK, manifest, "images")
+}
+
+// backfillChecksum hashes a cover uploaded before checksums were stored, and stores the result
+// so the next manifest does not read it again
+func (app *application) backfillChecksum(r *http.Request, gameID int, name string) (models.ImageChecksum, error) {
+	hash, size, err := app.hashImage(r.Context(), name)
+	if err != nil {
+		return models.ImageChecksum{}, err
+	}
+
+	checksum := models.ImageChecksum{File: name, SHA256: hash, Size: size}
+	err = app.db(r).SetGameImageChecksum(r.Context(), gameID, checksum)
+	if err != nil {
+		// el manifiesto sigue siendo valido, solo se volvera a calcular
+		app.log(r).Warn("storing image checksum", "error", err, "file", name)
+	}
+
+	return checksum, nil
+}
+
+// uploadGameImage handles PUT /v1/game/:id/image and /v2/games/:id/image, with the If-Match rules of updateGame
+func (app *application) uploadGameImage(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.log(r).Warn("invalid id parameter", "error", err)
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	game, err := app.db(r).GetOneGame(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if !app.checkIfMatch(w, r, game.Version) {
+		return
+	}
+
+	cover, err := app.readCover(r, id)
+	if err != nil {
+		app.uploadError(w, r, err)
+		return
+	}
+
+	imageName := coverName(game.Title, cover)
+	err = app.saveCover(r.Context(), imageName, cover)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	err = app.db(r).UpdateGameImage(r.Context(), id, imageName, game.Version)
+	if err != nil {
+		app.images.Remove(r.Context(), imageName)
+	}
+	if errors.Is(err, models.ErrEditConflict) {
+		app.errorJSON(w, r, err, http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	app.removeUnusedCover(r, game.ImageUrl)
+
+	err = app.saveCoverHash(w, r, id, cover)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	type jsonResp struct {
+		OK         bool  `json:"ok"`
+		Duplicates []int `json:"duplicates,omitempty"`
+	}
+
+	ok := jsonResp{
+		OK:         true,
+		Duplicates: cover.duplicates,
+	}
+	app.writeOK(w, r, ok)
+}
+
+// deleteGameImage handles DELETE /v1/game/:id/image and /v2/games/:id/image, with the If-Match rules of updateGame
+func (app *application) deleteGameImage(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.log(r).Warn("invalid id parameter", "error", err)
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	version, err := app.db(r).GetGameVersion(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if !app.checkIfMatch(w, r, version) {
+		return
+	}
+
+	image, err := app.db(r).GetGameImage(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	err = app.db(r).UpdateGameImage(r.Context(), id, "", version)
+	if errors.Is(err, models.ErrEditConflict) {
+		app.errorJSON(w, r, err, http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	// el archivo se borra cuando el juego ya no apunta a el
+	app.removeUnusedCover(r, image)
+
+	type jsonResp struct {
+		OK bool `json:"ok"`
+	}
+
+	ok := jsonResp{
+		OK: true,
+	}
+	app.writeOK(w, r, ok)
+}
+
+// hashImage returns the hex sha256 and size of an image
+func (app *application) hashImage(ctx context.Context, name string) (string, int64, error) {
+	file, err := app.images.Open(ctx, name)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, file)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
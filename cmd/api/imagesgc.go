@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"sort"
+	"time"
+)
+
+// imagesGCGracePeriod protects files that were just uploaded and whose row is not inserted yet
+const imagesGCGracePeriod = 10 * time.Minute
+
+// imageReferenceStore lists the image files used by games, in the trash or not, and by media items
+type imageReferenceStore interface {
+	GetReferencedImages(ctx context.Context) (map[int]string, error)
+	GetAllMediaFiles(ctx context.Context) (map[int]string, error)
+}
+
+// imageReferenceStore returns the store of the image references of the jobs
+func (app *application) imageReferenceStore() imageReferenceStore {
+	if app.imageReferences != nil {
+		return app.imageReferences
+	}
+
+	return &app.models.DB
+}
+
+// danglingImage is a game or media row whose file is not in the image store
+type danglingImage struct {
+	GameID  int    `json:"game_id,omitempty"`
+	MediaID int    `json:"media_id,omitempty"`
+	File    string `json:"file"`
+}
+
+// imagesGCReport is the result of reconciling the database with the image store
+type imagesGCReport struct {
+	Orphans  []string        `json:"orphans"`
+	Dangling []danglingImage `json:"dangling"`
+	Deleted  []string        `json:"deleted"`
+}
+
+// collectImages compares the images referenced by games and media with the files in the store.
+// Files no row references are orphans and are deleted if deleteOrphans is set;
+// rows that reference a missing file are reported as dangling
+func (app *application) collectImages(ctx context.Context, deleteOrphans bool) (*imagesGCReport, error) {
+	covers, err := app.imageReferenceStore().GetReferencedImages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaFiles, err := app.imageReferenceStore().GetAllMediaFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := app.images.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &imagesGCReport{
+		Orphans:  []string{},
+		Dangling: []danglingImage{},
+		Deleted:  []string{},
+	}
+
+	referenced := make(map[string]bool)
+	for gameID, image := range covers {
+		if image == "" {
+			continue
+		}
+		referenced[image] = true
+		if _, err := app.images.Stat(ctx, image); err != nil {
+			report.Dangling = append(report.Dangling, danglingImage{GameID: gameID, File: image})
+		}
+	}
+	for mediaID, image := range mediaFiles {
+		referenced[image] = true
+		if _, err := app.images.Stat(ctx, image); err != nil {
+			report.Dangling = append(report.Dangling, danglingImage{MediaID: mediaID, File: image})
+		}
+	}
+	sort.Slice(report.Dangling, func(i, j int) bool {
+		return report.Dangling[i].File < report.Dangling[j].File
+	})
+
+	for _, file := range files {
+		if referenced[file.Name()] || time.Since(file.ModTime()) < imagesGCGracePeriod {
+			continue
+		}
+		report.Orphans = append(report.Orphans, file.Name())
+
+		if deleteOrphans {
+			err := app.images.Remove(ctx, file.Name())
+			if err != nil {
+				app.logger.Error("removing orphaned image", "error", err, "file", file.Name())
+				continue
+			}
+			report.Deleted = append(report.Deleted, file.Name())
+		}
+	}
+
+	return report, nil
+}
+
+// runImagesGC handles the "images gc" command and prints the report as JSON
+func (app *application) runImagesGC(args []string) error {
+	fs := flag.NewFlagSet("images gc", flag.ExitOnError)
+	deleteOrphans := fs.Bool("delete", false, "Delete orphaned images instead of only reporting them")
+	fs.Parse(args)
+
+	report, err := app.collectImages(context.Background(), *deleteOrphans)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "\t")
+
+	return enc.Encode(report)
+}
+
+// imagesGCJob runs the images garbage collector every interval until ctx is done
+func (app *application) imagesGCJob(ctx context.Context, interval time.Duration, deleteOrphans bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		spanCtx, span := app.tracer.Start(context.Background(), "images gc")
+		report, err := app.collectImages(spanCtx, deleteOrphans)
+		endSpan(span, err)
+		if err != nil {
+			app.logger.Error("images gc", "error", err)
+			continue
+		}
+
+		app.logger.Info("images gc",
+			"orphans", len(report.Orphans), "dangling", len(report.Dangling), "deleted", len(report.Deleted))
+		for _, d := range report.Dangling {
+			app.logger.Warn("images gc: dangling reference", "file", d.File, "game_id", d.GameID, "media_id", d.MediaID)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// memoryImageReferences holds the covers and media files of the games, by game and media id
+type memoryImageReferences struct {
+	covers map[int]string
+	media  map[int]string
+}
+
+func (s memoryImageReferences) GetReferencedImages(ctx context.Context) (map[int]string, error) {
+	return s.covers, nil
+}
+
+func (s memoryImageReferences) GetAllMediaFiles(ctx context.Context) (map[int]string, error) {
+	return s.media, nil
+}
+
+func TestCollectImages(t *testing.T) {
+	refs := memoryImageReferences{
+		covers: map[int]string{
+			1: "Doom_cover_1.png",
+			// un juego en la papelera sigue usando su portada hasta que se purga
+			2: "Quake_cover_2.png",
+			3: "",
+			4: "Heretic_cover_4.png",
+		},
+		media: map[int]string{
+			5: "Doom_media_5.png",
+			6: "Hexen_media_6.png",
+		},
+	}
+
+	tests := []struct {
+		name          string
+		deleteOrphans bool
+		wantOrphans   []string
+		wantDangling  []danglingImage
+		wantDeleted   []string
+		wantFiles     []string
+	}{
+		{
+			name:          "report only",
+			deleteOrphans: false,
+			wantOrphans:   []string{"old_orphan.png"},
+			wantDangling:  []danglingImage{{GameID: 4, File: "Heretic_cover_4.png"}, {MediaID: 6, File: "Hexen_media_6.png"}},
+			wantDeleted:   []string{},
+			wantFiles:     []string{".hidden", "Doom_cover_1.png", "Doom_media_5.png", "Quake_cover_2.png", "new_upload.png", "old_orphan.png"},
+		},
+		{
+			name:          "delete orphans",
+			deleteOrphans: true,
+			wantOrphans:   []string{"old_orphan.png"},
+			wantDangling:  []danglingImage{{GameID: 4, File: "Heretic_cover_4.png"}, {MediaID: 6, File: "Hexen_media_6.png"}},
+			wantDeleted:   []string{"old_orphan.png"},
+			wantFiles:     []string{".hidden", "Doom_cover_1.png", "Doom_media_5.png", "Quake_cover_2.png", "new_upload.png"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			old := time.Now().Add(-2 * imagesGCGracePeriod)
+			for name, modTime := range map[string]time.Time{
+				"Doom_cover_1.png":  old,
+				"Quake_cover_2.png": old,
+				"Doom_media_5.png":  old,
+				"old_orphan.png":    old,
+				".hidden":           old,
+				// subido hace poco: su fila puede no estar insertada todavia
+				"new_upload.png": time.Now(),
+			} {
+				path := filepath.Join(dir, name)
+				if err := os.WriteFile(path, []byte("image"), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chtimes(path, modTime, modTime); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			app := &application{
+				logger:          slog.New(slog.NewTextHandler(io.Discard, nil)),
+				images:          &imageStore{dir: dir},
+				imageReferences: refs,
+			}
+
+			report, err := app.collectImages(context.Background(), tt.deleteOrphans)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(report.Orphans, tt.wantOrphans) {
+				t.Errorf("orphans = %v, want %v", report.Orphans, tt.wantOrphans)
+			}
+			if !reflect.DeepEqual(report.Dangling, tt.wantDangling) {
+				t.Errorf("dangling = %v, want %v", report.Dangling, tt.wantDangling)
+			}
+			if !reflect.DeepEqual(report.Deleted, tt.wantDeleted) {
+				t.Errorf("deleted = %v, want %v", report.Deleted, tt.wantDeleted)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files := []string{}
+			for _, entry := range entries {
+				files = append(files, entry.Name())
+			}
+			if !reflect.DeepEqual(files, tt.wantFiles) {
+				t.Errorf("files left = %v, want %v", files, tt.wantFiles)
+			}
+		})
+	}
+}
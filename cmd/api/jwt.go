@@ -0,0 +1,302 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// jwtIssuer is the "iss" claim of the tokens signed by the api
+const jwtIssuer = "CRUDWeb"
+
+// jwtLeeway is the clock skew tolerated when checking exp and nbf
+const jwtLeeway = 30 * time.Second
+
+var (
+	errInvalidToken = errors.New("invalid token")
+	errExpiredToken = errors.New("token has expired")
+	errNoJWTKey     = errors.New("no jwt signing key configured")
+)
+
+// jwtClaims are the claims of the tokens signed by the api
+type jwtClaims struct {
+	Subject   string `json:"sub"`
+	UserID    int    `json:"uid,omitempty"`
+	Role      string `json:"role,omitempty"`
+	Version   int    `json:"ver,omitempty"`
+	Issuer    string `json:"iss"`
+	IssuedAt  int64  `json:"iat"`
+	NotBefore int64  `json:"nbf"`
+	Expires   int64  `json:"exp"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+// jwtKey is a key that can verify, and maybe sign, tokens. Either secret (HS256) or publicKey (RS256) is set
+type jwtKey struct {
+	id         string
+	alg        string
+	secret     []byte
+	privateKey *rsa.PrivateKey
+	publicKey  *rsa.PublicKey
+}
+
+// jwtKeySet holds the key used to sign new tokens and every key still accepted for verification,
+// so keys can be rotated without invalidating the tokens already issued
+type jwtKeySet struct {
+	signing *jwtKey
+	keys    map[string]*jwtKey
+}
+
+// keyID derives a short, stable id from the key material
+func keyID(material []byte) string {
+	sum := sha256.Sum256(material)
+	return hex.EncodeToString(sum[:8])
+}
+
+func newHMACKey(secret string) *jwtKey {
+	return &jwtKey{
+		id:     keyID([]byte(secret)),
+		alg:    "HS256",
+		secret: []byte(secret),
+	}
+}
+
+// loadRSAKey reads a PEM file with a RSA private key (PKCS#1 or PKCS#8) or a public key (PKIX)
+func loadRSAKey(path string) (*jwtKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+
+	key := &jwtKey{alg: "RS256"}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key.privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		var parsed interface{}
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err == nil {
+			var ok bool
+			if key.privateKey, ok = parsed.(*rsa.PrivateKey); !ok {
+				err = fmt.Errorf("%s: not a RSA key", path)
+			}
+		}
+	case "PUBLIC KEY":
+		var parsed interface{}
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+		if err == nil {
+			var ok bool
+			if key.publicKey, ok = parsed.(*rsa.PublicKey); !ok {
+				err = fmt.Errorf("%s: not a RSA key", path)
+			}
+		}
+	default:
+		err = fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if key.privateKey != nil {
+		key.publicKey = &key.privateKey.PublicKey
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.publicKey)
+	if err != nil {
+		return nil, err
+	}
+	key.id = keyID(der)
+
+	return key, nil
+}
+
+// newJWTKeySet builds the key set from the config. The RSA key, if any, signs; otherwise the HMAC secret does.
+// Previous secrets and keys are only used to verify. It returns errNoJWTKey if neither is configured
+func newJWTKeySet(cfg config) (*jwtKeySet, error) {
+	ks := &jwtKeySet{keys: make(map[string]*jwtKey)}
+
+	add := func(key *jwtKey) {
+		ks.keys[key.id] = key
+		if ks.signing == nil {
+			ks.signing = key
+		}
+	}
+
+	if cfg.jwt.rsaKey != "" {
+		key, err := loadRSAKey(cfg.jwt.rsaKey)
+		if err != nil {
+			return nil, err
+		}
+		if key.privateKey == nil {
+			return nil, fmt.Errorf("%s: a private key is needed to sign tokens", cfg.jwt.rsaKey)
+		}
+		add(key)
+	}
+	if cfg.jwt.secret != "" {
+		add(newHMACKey(cfg.jwt.secret))
+	}
+
+	for _, secret := range splitList(cfg.jwt.previousSecrets) {
+		key := newHMACKey(secret)
+		ks.keys[key.id] = key
+	}
+	for _, path := range splitList(cfg.jwt.previousRSAKeys) {
+		key, err := loadRSAKey(path)
+		if err != nil {
+			return nil, err
+		}
+		ks.keys[key.id] = key
+	}
+
+	if ks.signing == nil {
+		return nil, errNoJWTKey
+	}
+
+	return ks, nil
+}
+
+// newEphemeralJWTKeySet returns a random HMAC key, for development only: tokens do not survive a restart
+func newEphemeralJWTKeySet() (*jwtKeySet, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	key := newHMACKey(hex.EncodeToString(secret))
+	return &jwtKeySet{signing: key, keys: map[string]*jwtKey{key.id: key}}, nil
+}
+
+// Sign returns a signed token with the claims
+func (ks *jwtKeySet) Sign(claims interface{}) (string, error) {
+	header, err := json.Marshal(jwtHeader{Alg: ks.signing.alg, Typ: "JWT", Kid: ks.signing.id})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var signature []byte
+	switch ks.signing.alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, ks.signing.secret)
+		mac.Write([]byte(signingInput))
+		signature = mac.Sum(nil)
+	case "RS256":
+		digest := sha256.Sum256([]byte(signingInput))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, ks.signing.privateKey, crypto.SHA256, digest[:])
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Verify checks the signature, issuer and validity period of token and decodes its claims into claims
+func (ks *jwtKeySet) Verify(token string, claims *jwtClaims) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errInvalidToken
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return errInvalidToken
+	}
+	var header jwtHeader
+	err = json.Unmarshal(headerJSON, &header)
+	if err != nil {
+		return errInvalidToken
+	}
+
+	// el algoritmo lo decide la clave, nunca el token
+	key, ok := ks.keys[header.Kid]
+	if !ok || header.Alg != key.alg {
+		return errInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return errInvalidToken
+	}
+
+	signingInput := parts[0] + "." + parts[1]
+	switch key.alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, key.secret)
+		mac.Write([]byte(signingInput))
+		if subtle.ConstantTimeCompare(mac.Sum(nil), signature) != 1 {
+			return errInvalidToken
+		}
+	case "RS256":
+		digest := sha256.Sum256([]byte(signingInput))
+		if rsa.VerifyPKCS1v15(key.publicKey, crypto.SHA256, digest[:], signature) != nil {
+			return errInvalidToken
+		}
+	default:
+		return errInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return errInvalidToken
+	}
+	err = json.Unmarshal(payload, claims)
+	if err != nil {
+		return errInvalidToken
+	}
+
+	now := time.Now()
+	if claims.Issuer != jwtIssuer {
+		return errInvalidToken
+	}
+	if claims.NotBefore != 0 && now.Add(jwtLeeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return errInvalidToken
+	}
+	if claims.Expires == 0 || now.Add(-jwtLeeway).After(time.Unix(claims.Expires, 0)) {
+		return errExpiredToken
+	}
+
+	return nil
+}
+
+// splitList splits a comma separated flag value, dropping empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
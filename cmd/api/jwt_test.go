@@ -0,0 +1,240 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeRSAKey writes a new RSA key as a PKCS#1 private key and a PKIX public key and returns their paths
+func writeRSAKey(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privatePath := filepath.Join(dir, name+".pem")
+	publicPath := filepath.Join(dir, name+".pub.pem")
+	err = os.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return privatePath, publicPath
+}
+
+// signHS256 signs header and claims with secret, without going through a key set
+func signHS256(t *testing.T, header jwtHeader, claims interface{}, secret []byte) string {
+	t.Helper()
+
+	encodedHeader, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(encodedHeader) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestNewJWTKeySet(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, rsaPublic := writeRSAKey(t, dir, "current")
+	oldKey, _ := writeRSAKey(t, dir, "old")
+
+	tests := []struct {
+		name        string
+		secret      string
+		previous    string
+		rsaKey      string
+		previousRSA string
+		wantAlg     string
+		wantKeys    int
+		wantErr     error
+		wantAnyErr  bool
+	}{
+		{name: "nothing configured", wantErr: errNoJWTKey},
+		{name: "only previous secrets", previous: "old-secret", wantErr: errNoJWTKey},
+		{name: "secret", secret: "secret", wantAlg: "HS256", wantKeys: 1},
+		{name: "secret and previous secrets", secret: "secret", previous: "old-1, ,old-2", wantAlg: "HS256", wantKeys: 3},
+		{name: "rsa key signs before the secret", secret: "secret", rsaKey: rsaKey, wantAlg: "RS256", wantKeys: 2},
+		{name: "previous rsa keys", rsaKey: rsaKey, previousRSA: oldKey + "," + rsaPublic, wantAlg: "RS256", wantKeys: 2},
+		{name: "public key cannot sign", rsaKey: rsaPublic, wantAnyErr: true},
+		{name: "missing rsa key", rsaKey: filepath.Join(dir, "missing.pem"), wantAnyErr: true},
+		{name: "missing previous rsa key", secret: "secret", previousRSA: filepath.Join(dir, "missing.pem"), wantAnyErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg config
+			cfg.jwt.secret = tt.secret
+			cfg.jwt.previousSecrets = tt.previous
+			cfg.jwt.rsaKey = tt.rsaKey
+			cfg.jwt.previousRSAKeys = tt.previousRSA
+
+			ks, err := newJWTKeySet(cfg)
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			case tt.wantAnyErr:
+				if err == nil {
+					t.Fatal("want an error")
+				}
+				if errors.Is(err, errNoJWTKey) {
+					t.Fatalf("err = %v, a broken key must not be taken as a missing one", err)
+				}
+				return
+			case err != nil:
+				t.Fatal(err)
+			}
+
+			if ks.signing.alg != tt.wantAlg {
+				t.Errorf("signing alg = %s, want %s", ks.signing.alg, tt.wantAlg)
+			}
+			if len(ks.keys) != tt.wantKeys {
+				t.Errorf("got %d keys, want %d", len(ks.keys), tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestJWTVerify(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, _ := writeRSAKey(t, dir, "current")
+
+	var cfg config
+	cfg.jwt.secret = "current-secret"
+	cfg.jwt.previousSecrets = "old-secret"
+	current, err := newJWTKeySet(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.jwt.rsaKey = rsaKey
+	withRSA, err := newJWTKeySet(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg = config{}
+	cfg.jwt.secret = "old-secret"
+	old, err := newJWTKeySet(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.jwt.secret = "unknown-secret"
+	unknown, err := newJWTKeySet(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	claims := func(change func(*jwtClaims)) jwtClaims {
+		c := jwtClaims{
+			Subject:   "admin",
+			UserID:    1,
+			Role:      "admin",
+			Version:   3,
+			Issuer:    jwtIssuer,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			Expires:   now.Add(time.Hour).Unix(),
+		}
+		if change != nil {
+			change(&c)
+		}
+		return c
+	}
+	sign := func(ks *jwtKeySet, c jwtClaims) string {
+		token, err := ks.Sign(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	valid := sign(current, claims(nil))
+	parts := strings.Split(valid, ".")
+	forged, err := json.Marshal(claims(func(c *jwtClaims) { c.Role = "superadmin" }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// un token HS256 firmado con la clave publica RSA como secreto no debe pasar por RS256
+	rsaSigning := withRSA.signing
+	public, err := x509.MarshalPKIXPublicKey(rsaSigning.publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	confused := signHS256(t, jwtHeader{Alg: "HS256", Typ: "JWT", Kid: rsaSigning.id}, claims(nil), public)
+
+	tests := []struct {
+		name    string
+		ks      *jwtKeySet
+		token   string
+		wantErr error
+	}{
+		{name: "valid", ks: current, token: valid},
+		{name: "rsa", ks: withRSA, token: sign(withRSA, claims(nil))},
+		{name: "hmac key of a set signed with rsa", ks: withRSA, token: valid},
+		{name: "signed with a previous secret", ks: current, token: sign(old, claims(nil))},
+		{name: "signed with an unknown key", ks: current, token: sign(unknown, claims(nil)), wantErr: errInvalidToken},
+		{name: "expired", ks: current, token: sign(current, claims(func(c *jwtClaims) { c.Expires = now.Add(-time.Minute).Unix() })), wantErr: errExpiredToken},
+		{name: "expired within the leeway", ks: current, token: sign(current, claims(func(c *jwtClaims) { c.Expires = now.Add(-jwtLeeway / 2).Unix() }))},
+		{name: "without exp", ks: current, token: sign(current, claims(func(c *jwtClaims) { c.Expires = 0 })), wantErr: errExpiredToken},
+		{name: "not valid yet", ks: current, token: sign(current, claims(func(c *jwtClaims) { c.NotBefore = now.Add(time.Minute).Unix() })), wantErr: errInvalidToken},
+		{name: "not valid yet within the leeway", ks: current, token: sign(current, claims(func(c *jwtClaims) { c.NotBefore = now.Add(jwtLeeway / 2).Unix() }))},
+		{name: "other issuer", ks: current, token: sign(current, claims(func(c *jwtClaims) { c.Issuer = "other" })), wantErr: errInvalidToken},
+		{name: "changed payload", ks: current, token: parts[0] + "." + base64.RawURLEncoding.EncodeToString(forged) + "." + parts[2], wantErr: errInvalidToken},
+		{name: "no signature", ks: current, token: parts[0] + "." + parts[1] + ".", wantErr: errInvalidToken},
+		{name: "alg none", ks: current, token: signHS256(t, jwtHeader{Alg: "none", Typ: "JWT", Kid: current.signing.id}, claims(nil), nil), wantErr: errInvalidToken},
+		{name: "hmac with the rsa public key", ks: withRSA, token: confused, wantErr: errInvalidToken},
+		{name: "two parts", ks: current, token: parts[0] + "." + parts[1], wantErr: errInvalidToken},
+		{name: "not base64", ks: current, token: "!." + parts[1] + "." + parts[2], wantErr: errInvalidToken},
+		{name: "empty", ks: current, token: "", wantErr: errInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got jwtClaims
+			err := tt.ks.Verify(tt.token, &got)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (got.Subject != "admin" || got.UserID != 1 || got.Role != "admin" || got.Version != 3) {
+				t.Errorf("claims = %+v, want the signed ones", got)
+			}
+		})
+	}
+}
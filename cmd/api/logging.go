@@ -0,0 +1,189 @@
+package main
+
+import (
+	"CRUDWeb/models"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	requestIDContextKey   = contextKey("request_id")
+	loggerContextKey      = contextKey("logger")
+	requestInfoContextKey = contextKey("request_info")
+)
+
+// maxRequestIDLength is the longest X-Request-ID accepted from clients
+const maxRequestIDLength = 128
+
+// newLogger returns a leveled logger writing JSON or logfmt-style text
+func newLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	err := lvl.UnmarshalText([]byte(level))
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+}
+
+// requestInfo is filled while the request goes through the router, so the access log knows the matched route
+type requestInfo struct {
+	route     string
+	principal string
+}
+
+// router is an httprouter that records the route pattern of every request
+type router struct {
+	*httprouter.Router
+}
+
+func newRouter() router {
+	return router{httprouter.New()}
+}
+
+// HandlerFunc registers handler for method and path, recording path as the route of the request
+func (rt router) HandlerFunc(method, path string, handler http.HandlerFunc) {
+	rt.Router.HandlerFunc(method, path, func(w http.ResponseWriter, r *http.Request) {
+		if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+			info.route = path
+		}
+
+		handler(w, r)
+	})
+}
+
+// requestIDFromContext returns the id of the request, if any
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
+
+// log returns the logger of the request, which adds its id to every line
+func (app *application) log(r *http.Request) *slog.Logger {
+	if logger, ok := r.Context().Value(loggerContextKey).(*slog.Logger); ok {
+		return logger
+	}
+
+	return app.logger
+}
+
+// db returns the database models logging with the logger of the request
+func (app *application) db(r *http.Request) *models.DBModels {
+	return app.models.DB.WithLogger(app.log(r))
+}
+
+// validRequestID tells whether a client supplied X-Request-ID can be reused
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// requestID propagates the X-Request-ID of the request, or assigns a new one, and
+// stores it in the context together with a logger that includes it
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			b := make([]byte, 16)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+		ctx = context.WithValue(ctx, loggerContextKey, app.logger.With("request_id", id))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// statusRecorder keeps the status and size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the original writer
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// logRequest writes one access log line per request with its route, status, latency and size
+func (app *application) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestInfo{}
+		rec := &statusRecorder{ResponseWriter: w}
+
+		ctx := context.WithValue(r.Context(), requestInfoContextKey, info)
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case rec.status >= 500:
+			level = slog.LevelError
+		case rec.status >= 400:
+			level = slog.LevelWarn
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("route", info.route),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int64("bytes", rec.bytes),
+			slog.String("remote_addr", r.RemoteAddr),
+		}
+		if info.principal != "" {
+			attrs = append(attrs, slog.String("principal", info.principal))
+		}
+
+		app.log(r).LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"CRUDWeb/models"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// mediaKinds are the accepted kinds of media in a game gallery
+var mediaKinds = map[string]bool{
+	"screenshot": true,
+	"artwork":    true,
+}
+
+// setMediaUrls fills the url of every media item of a game, in the api version of the request
+func setMediaUrls(r *http.Request, game *models.Game) {
+	for _, media := range game.Media {
+		media.Url = fmt.Sprintf("%s/media/%d", gamePath(r, game.ID), media.ID)
+	}
+}
+
+// mediaParams returns the game id and media id of the request
+func mediaParams(r *http.Request) (int, int, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	gameID, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		return 0, 0, errors.New("invalid id parameter")
+	}
+
+	mediaID, err := strconv.Atoi(params.ByName("media"))
+	if err != nil {
+		return 0, 0, errors.New("invalid media parameter")
+	}
+
+	return gameID, mediaID, nil
+}
+
+// getGameMedia handles GET /v1/game/:id/media and /v2/games/:id/media
+func (app *application) getGameMedia(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.log(r).Warn("invalid id parameter", "error", err)
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	media, err := app.db(r).GetGameMedia(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	setMediaUrls(r, &models.Game{ID: id, Media: media})
+
+	app.writeJSON(w, http.StatusOK, media, "media")
+}
+
+// getMediaImage handles GET /v1/game/:id/media/:media and /v2/games/:id/media/:media
+func (app *application) getMediaImage(w http.ResponseWriter, r *http.Request) {
+	gameID, mediaID, err := mediaParams(r)
+	if err != nil {
+		app.log(r).Warn(err.Error())
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	media, err := app.db(r).GetOneMedia(r.Context(), gameID, mediaID)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	app.serveImage(w, r, media.FileName)
+}
+
+// insertMedia handles PUT /v1/game/:id/media and POST /v2/games/:id/media
+// expects a multipart form with the "image" file and optional "kind" and "caption" fields.
+// The media is part of the game: its writes take If-Match with the ETag of the game, like updateGame
+func (app *application) insertMedia(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.log(r).Warn("invalid id parameter", "error", err)
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	game, err := app.db(r).GetOneGame(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if !app.checkIfMatch(w, r, game.Version) {
+		return
+	}
+
+	upload, err := app.readImage(r)
+	if err != nil {
+		app.uploadError(w, r, err)
+		return
+	}
+
+	kind := r.FormValue("kind")
+	if kind == "" {
+		kind = "screenshot"
+	}
+	if !mediaKinds[kind] {
+		app.errorJSON(w, r, errors.New("invalid media kind"))
+		return
+	}
+
+	media := models.Media{
+		GameID:   id,
+		Kind:     kind,
+		FileName: fmt.Sprintf("%s_%s_%d%s", game.Title, kind, time.Now().UnixNano(), imageFormats[upload.format]),
+		Caption:  r.FormValue("caption"),
+	}
+
+	err = app.images.Save(r.Context(), media.FileName, bytes.NewReader(upload.data))
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	err = app.db(r).InsertMedia(r.Context(), &media, game.Version)
+	if err != nil {
+		app.images.Remove(r.Context(), media.FileName)
+	}
+	if errors.Is(err, models.ErrEditConflict) {
+		app.errorJSON(w, r, err, http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	setMediaUrls(r, &models.Game{ID: id, Media: []*models.Media{&media}})
+
+	app.writeCreated(w, r, media.Url, media, "media")
+}
+
+type mediaPayload struct {
+	Caption  *string `json:"caption"`
+	Position *int    `json:"position"`
+}
+
+// updateMedia handles PUT /v1/game/:id/media/:media and PATCH /v2/games/:id/media/:media
+// changes the caption and/or moves the item to another position of the gallery, with the If-Match rules of insertMedia
+func (app *application) updateMedia(w http.ResponseWriter, r *http.Request) {
+	gameID, mediaID, err := mediaParams(r)
+	if err != nil {
+		app.log(r).Warn(err.Error())
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	version, err := app.db(r).GetGameVersion(r.Context(), gameID)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if !app.checkIfMatch(w, r, version) {
+		return
+	}
+
+	var payload mediaPayload
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	// pie y posicion se cambian juntos o ninguno, con una sola subida de version
+	err = app.db(r).UpdateMedia(r.Context(), gameID, mediaID, payload.Caption, payload.Position, version)
+	if errors.Is(err, models.ErrEditConflict) {
+		app.errorJSON(w, r, err, http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	type jsonResp struct {
+		OK bool `json:"ok"`
+	}
+
+	ok := jsonResp{
+		OK: true,
+	}
+	app.writeOK(w, r, ok)
+}
+
+// deleteMedia handles DELETE /v1/game/:id/media/:media and /v2/games/:id/media/:media, with the If-Match rules of insertMedia
+func (app *application) deleteMedia(w http.ResponseWriter, r *http.Request) {
+	gameID, mediaID, err := mediaParams(r)
+	if err != nil {
+		app.log(r).Warn(err.Error())
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	version, err := app.db(r).GetGameVersion(r.Context(), gameID)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if !app.checkIfMatch(w, r, version) {
+		return
+	}
+
+	media, err := app.db(r).GetOneMedia(r.Context(), gameID, mediaID)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	err = app.db(r).DeleteMedia(r.Context(), gameID, mediaID, version)
+	if errors.Is(err, models.ErrEditConflict) {
+		app.errorJSON(w, r, err, http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	err = app.images.Remove(r.Context(), media.FileName)
+	if err != nil {
+		app.log(r).Error("removing media image", "error", err, "file", media.FileName)
+	}
+
+	type jsonResp struct {
+		OK bool `json:"ok"`
+	}
+
+	ok := jsonResp{
+		OK: true,
+	}
+	app.writeOK(w, r, ok)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsNamespace prefixes every metric of the api
+const metricsNamespace = "crudweb"
+
+// metrics are the prometheus collectors of the api
+type metrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	inFlight        prometheus.Gauge
+	imageBytes      prometheus.Counter
+	queryDuration   *prometheus.HistogramVec
+}
+
+// newMetrics registers the api collectors, the pool stats of db and the go runtime ones in a new registry
+func newMetrics(db *sql.DB) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests by route and status.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests by route and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_in_flight",
+			Help:      "Number of HTTP requests being served.",
+		}),
+		imageBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "image_bytes_served_total",
+			Help:      "Bytes of images sent to clients, single images and archives.",
+		}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "db_query_duration_seconds",
+			Help:      "Duration of the database model methods.",
+			Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 3},
+		}, []string{"query"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.requestDuration,
+		m.inFlight,
+		m.imageBytes,
+		m.queryDuration,
+		collectors.NewDBStatsCollector(db, "gamesdb"),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+// observeQuery records how long a database model method took
+func (m *metrics) observeQuery(query string, duration time.Duration) {
+	m.queryDuration.WithLabelValues(query).Observe(duration.Seconds())
+}
+
+// handler serves the metrics in the prometheus text format
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// metricsServer serves only /metrics on port. It is kept off the api listener: the metrics need no
+// credentials and tell the routes, the traffic and the state of the pool
+func (app *application) metricsServer(port int) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", app.metrics.handler())
+
+	return &http.Server{
+		Addr:         fmt.Sprintf(":%d", port),
+		Handler:      mux,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		IdleTimeout:  app.config.http.idleTimeout,
+		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelWarn),
+	}
+}
+
+// metricsMethod returns the method label of a request. Methods net/http does not define are counted
+// as OTHER, since any token is a valid method and each one would be a new series
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return "OTHER"
+	}
+}
+
+// instrument counts the requests and measures their latency by route and status.
+// Requests that match no route, or use an unknown method, are grouped together so they don't create new series
+func (app *application) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		app.metrics.inFlight.Inc()
+		defer app.metrics.inFlight.Dec()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		route := "unmatched"
+		if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok && info.route != "" {
+			route = info.route
+		}
+
+		method := metricsMethod(r.Method)
+		status := strconv.Itoa(rec.status)
+		app.metrics.requests.WithLabelValues(method, route, status).Inc()
+		app.metrics.requestDuration.WithLabelValues(method, route, status).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"CRUDWeb/models"
+	"database/sql"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestInstrument(t *testing.T) {
+	// sql.Open no conecta: basta para las estadisticas del pool
+	db, err := sql.Open("postgres", "postgres://test@127.0.0.1:1/test?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	app := &application{
+		logger:  logger,
+		models:  models.NewModels(db, logger),
+		metrics: newMetrics(db),
+		tracer:  trace.NewNoopTracerProvider().Tracer(tracerName),
+	}
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantRoute  string
+	}{
+		{path: "/v1/game/1/media/2", wantRoute: "/v1/game/:id/media/:media"},
+		{path: "/v1/game/2/media/3", wantRoute: "/v1/game/:id/media/:media"},
+		{path: "/nowhere", wantStatus: http.StatusNotFound, wantRoute: "unmatched"},
+		{path: "/nowhere/else", wantStatus: http.StatusNotFound, wantRoute: "unmatched"},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		app.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if tt.wantStatus != 0 && rec.Code != tt.wantStatus {
+			t.Errorf("GET %s: status = %d, want %d", tt.path, rec.Code, tt.wantStatus)
+		}
+	}
+
+	families, err := app.metrics.registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := map[string]float64{}
+	for _, family := range families {
+		if family.GetName() != metricsNamespace+"_http_requests_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "route" {
+					routes[label.GetValue()] += m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+
+	// las rutas se cuentan por su patron, no por la url de cada peticion
+	want := map[string]float64{}
+	for _, tt := range tests {
+		want[tt.wantRoute]++
+	}
+	for route, n := range want {
+		if routes[route] != n {
+			t.Errorf("requests of route %s = %v, want %v", route, routes[route], n)
+		}
+	}
+	if len(routes) != len(want) {
+		t.Errorf("requests by route = %v, want %v", routes, want)
+	}
+}
+
+func TestMetricsMethod(t *testing.T) {
+	tests := []struct {
+		method string
+		want   string
+	}{
+		{http.MethodGet, http.MethodGet},
+		{http.MethodHead, http.MethodHead},
+		{http.MethodPost, http.MethodPost},
+		{http.MethodPut, http.MethodPut},
+		{http.MethodPatch, http.MethodPatch},
+		{http.MethodDelete, http.MethodDelete},
+		{http.MethodOptions, http.MethodOptions},
+		{"get", "OTHER"},
+		{"PROPFIND", "OTHER"},
+		{"X-RANDOM-1234", "OTHER"},
+	}
+
+	for _, tt := range tests {
+		if got := metricsMethod(tt.method); got != tt.want {
+			t.Errorf("metricsMethod(%q) = %q, want %q", tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestMetricsListener(t *testing.T) {
+	// sql.Open no conecta: basta para las estadisticas del pool
+	db, err := sql.Open("postgres", "postgres://test@127.0.0.1:1/test?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	app := &application{
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		metrics: newMetrics(db),
+		tracer:  trace.NewNoopTracerProvider().Tracer(tracerName),
+	}
+
+	tests := []struct {
+		name       string
+		handler    http.Handler
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{name: "not on the api", handler: app.routes(), method: http.MethodGet, path: "/metrics", wantStatus: http.StatusNotFound},
+		{name: "on the metrics port", handler: app.metricsServer(9090).Handler, method: http.MethodGet, path: "/metrics", wantStatus: http.StatusOK},
+		{name: "nothing else on the metrics port", handler: app.metricsServer(9090).Handler, method: http.MethodGet, path: "/v1/games", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+
+	t.Run("unknown methods share a series", func(t *testing.T) {
+		for _, method := range []string{"FOO", "BAR", "BAZ"} {
+			app.routes().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/v1/games", nil))
+		}
+
+		families, err := app.metrics.registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		methods := map[string]float64{}
+		for _, family := range families {
+			if family.GetName() != metricsNamespace+"_http_requests_total" {
+				continue
+			}
+			for _, m := range family.GetMetric() {
+				for _, label := range m.GetLabel() {
+					if label.GetName() == "method" {
+						methods[label.GetValue()] += m.GetCounter().GetValue()
+					}
+				}
+			}
+		}
+
+		// la peticion GET es la del primer caso
+		want := map[string]float64{http.MethodGet: 1, "OTHER": 3}
+		if len(methods) != len(want) || methods[http.MethodGet] != want[http.MethodGet] || methods["OTHER"] != want["OTHER"] {
+			t.Errorf("requests by method = %v, want %v", methods, want)
+		}
+	})
+}
@@ -1,12 +1,90 @@
 package main
 
-import "net/http"
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsPolicy is the parsed cors config
+type corsPolicy struct {
+	allowAll    bool
+	origins     map[string]bool
+	methods     string
+	headers     string
+	credentials bool
+	maxAge      string
+}
+
+// newCORSPolicy builds the cors policy. Without configured origins, development allows any origin
+// and production none, so a production deployment only talks to the origins it lists
+func newCORSPolicy(cfg config) (*corsPolicy, error) {
+	origins := splitList(cfg.cors.origins)
+	if len(origins) == 0 && cfg.env != "production" {
+		origins = []string{"*"}
+	}
+
+	policy := &corsPolicy{
+		origins:     make(map[string]bool),
+		methods:     strings.Join(splitList(cfg.cors.methods), ", "),
+		headers:     strings.Join(splitList(cfg.cors.headers), ", "),
+		credentials: cfg.cors.credentials,
+		maxAge:      strconv.Itoa(int(cfg.cors.maxAge / time.Second)),
+	}
+	for _, origin := range origins {
+		if origin == "*" {
+			policy.allowAll = true
+			continue
+		}
+		policy.origins[strings.TrimSuffix(origin, "/")] = true
+	}
+
+	// con credenciales el navegador no acepta "*", y reflejar cualquier origen seria equivalente a no tener politica
+	if policy.allowAll && policy.credentials {
+		return nil, errors.New("cors: credentials cannot be allowed for any origin, list the allowed origins")
+	}
+
+	return policy, nil
+}
+
+// allows tells whether requests from origin may read the responses
+func (p *corsPolicy) allows(origin string) bool {
+	return p.allowAll || p.origins[origin]
+}
 
 func (app *application) enableCORS(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		if origin == "" || !app.cors.allows(origin) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		if app.cors.allowAll {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if app.cors.credentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		// preflight: se contesta aqui en vez de dejar que httprouter lo gestione de forma generica
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", app.cors.methods)
+			w.Header().Set("Access-Control-Allow-Headers", app.cors.headers)
+			w.Header().Set("Access-Control-Max-Age", app.cors.maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Access-Control-Expose-Headers", "RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, RateLimit-Policy, Retry-After, Location, Warning, Deprecation, Sunset, Link, Accept-Patch, ETag, Idempotent-Replayed")
 
 		handler.ServeHTTP(w, r)
 	})
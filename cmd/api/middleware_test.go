@@ -0,0 +1,215 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCORSConfig is the cors config of the tests, with the origins and credentials of each case
+func testCORSConfig(env, origins string, credentials bool) config {
+	var cfg config
+	cfg.env = env
+	cfg.cors.origins = origins
+	cfg.cors.methods = "GET,PUT , DELETE"
+	cfg.cors.headers = "Content-Type, If-Match"
+	cfg.cors.credentials = credentials
+	cfg.cors.maxAge = 10 * time.Minute
+
+	return cfg
+}
+
+func TestCORSPolicyAllows(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         string
+		origins     string
+		credentials bool
+		origin      string
+		want        bool
+		wantErr     bool
+	}{
+		{name: "development allows any origin by default", env: "development", origin: "https://games.example", want: true},
+		{name: "production allows none by default", env: "production", origin: "https://games.example", want: false},
+		{name: "listed origin", env: "production", origins: "https://games.example, https://admin.example", origin: "https://admin.example", want: true},
+		{name: "listed with a trailing slash", env: "production", origins: "https://games.example/", origin: "https://games.example", want: true},
+		{name: "other scheme", env: "production", origins: "https://games.example", origin: "http://games.example", want: false},
+		{name: "other port", env: "production", origins: "https://games.example", origin: "https://games.example:8443", want: false},
+		{name: "subdomain", env: "production", origins: "https://games.example", origin: "https://evil.games.example", want: false},
+		{name: "suffix", env: "production", origins: "https://games.example", origin: "https://games.example.evil", want: false},
+		{name: "explicit any", env: "production", origins: "*", origin: "https://games.example", want: true},
+		{name: "credentials with listed origins", env: "production", origins: "https://games.example", credentials: true, origin: "https://games.example", want: true},
+		{name: "credentials with any origin", env: "production", origins: "*", credentials: true, wantErr: true},
+		{name: "credentials with the development default", env: "development", credentials: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := newCORSPolicy(testCORSConfig(tt.env, tt.origins, tt.credentials))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newCORSPolicy() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if got := policy.allows(tt.origin); got != tt.want {
+				t.Errorf("allows(%q) = %t, want %t", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnableCORS(t *testing.T) {
+	tests := []struct {
+		name          string
+		origins       string
+		credentials   bool
+		method        string
+		origin        string
+		requestMethod string
+		wantStatus    int
+		wantHeaders   map[string]string
+		wantNext      bool
+	}{
+		{
+			name:       "same origin request",
+			origins:    "https://games.example",
+			method:     http.MethodGet,
+			wantStatus: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin": "",
+				"Vary":                        "Origin",
+			},
+			wantNext: true,
+		},
+		{
+			name:       "allowed origin",
+			origins:    "https://games.example",
+			method:     http.MethodGet,
+			origin:     "https://games.example",
+			wantStatus: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "https://games.example",
+				"Access-Control-Allow-Credentials": "",
+				"Access-Control-Allow-Methods":     "",
+			},
+			wantNext: true,
+		},
+		{
+			name:       "any origin",
+			origins:    "*",
+			method:     http.MethodGet,
+			origin:     "https://games.example",
+			wantStatus: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin": "*",
+			},
+			wantNext: true,
+		},
+		{
+			name:        "allowed origin with credentials",
+			origins:     "https://games.example",
+			credentials: true,
+			method:      http.MethodGet,
+			origin:      "https://games.example",
+			wantStatus:  http.StatusOK,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "https://games.example",
+				"Access-Control-Allow-Credentials": "true",
+			},
+			wantNext: true,
+		},
+		{
+			name:       "other origin",
+			origins:    "https://games.example",
+			method:     http.MethodGet,
+			origin:     "https://evil.example",
+			wantStatus: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":   "",
+				"Access-Control-Expose-Headers": "",
+			},
+			wantNext: true,
+		},
+		{
+			name:          "preflight",
+			origins:       "https://games.example",
+			method:        http.MethodOptions,
+			origin:        "https://games.example",
+			requestMethod: http.MethodPut,
+			wantStatus:    http.StatusNoContent,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":  "https://games.example",
+				"Access-Control-Allow-Methods": "GET, PUT, DELETE",
+				"Access-Control-Allow-Headers": "Content-Type, If-Match",
+				"Access-Control-Max-Age":       "600",
+				"Vary":                         "Origin, Access-Control-Request-Method, Access-Control-Request-Headers",
+			},
+		},
+		{
+			name:          "preflight from another origin",
+			origins:       "https://games.example",
+			method:        http.MethodOptions,
+			origin:        "https://evil.example",
+			requestMethod: http.MethodPut,
+			wantStatus:    http.StatusOK,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":  "",
+				"Access-Control-Allow-Methods": "",
+			},
+			wantNext: true,
+		},
+		{
+			name:       "options without a request method",
+			origins:    "https://games.example",
+			method:     http.MethodOptions,
+			origin:     "https://games.example",
+			wantStatus: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":  "https://games.example",
+				"Access-Control-Allow-Methods": "",
+			},
+			wantNext: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := newCORSPolicy(testCORSConfig("production", tt.origins, tt.credentials))
+			if err != nil {
+				t.Fatal(err)
+			}
+			app := &application{cors: policy}
+
+			var next bool
+			handler := app.enableCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				next = true
+			}))
+
+			req := httptest.NewRequest(tt.method, "/v2/games", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.requestMethod != "" {
+				req.Header.Set("Access-Control-Request-Method", tt.requestMethod)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if next != tt.wantNext {
+				t.Errorf("next handler called = %t, want %t", next, tt.wantNext)
+			}
+			for header, want := range tt.wantHeaders {
+				if got := strings.Join(rec.Header().Values(header), ", "); got != want {
+					t.Errorf("%s = %q, want %q", header, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"CRUDWeb/models"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Media types of the partial updates of a game
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// patchGame handles PATCH /v1/game/:id and PATCH /v2/games/:id.
+// The body is a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902) applied to the game fields that
+// can be written, the same ones of the game JSON of insertGame. A plain application/json body is taken
+// as a merge patch. Only the columns and the genres and modes that change are written.
+// If-Match, when sent, must have the ETag of the game; http-require-if-match makes it mandatory
+func (app *application) patchGame(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.log(r).Warn("invalid id parameter", "error", err)
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != mergePatchType && mediaType != jsonPatchType && mediaType != "application/json" {
+		w.Header().Set("Accept-Patch", mergePatchType+", "+jsonPatchType)
+		app.errorJSON(w, r, fmt.Errorf("unsupported content type %q", mediaType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxJSONBodySize)
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		app.errorJSON(w, r, fmt.Errorf("body must not be larger than %d bytes", maxJSONBodySize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	current, err := app.db(r).GetOneGame(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if !app.checkIfMatch(w, r, current.Version) {
+		return
+	}
+
+	payload, err := patchPayload(current, mediaType, patch)
+	var invalid *invalidPatchError
+	switch {
+	case errors.Is(err, jsonpatch.ErrTestFailed):
+		app.errorJSON(w, r, err, http.StatusConflict)
+		return
+	case errors.As(err, &invalid):
+		app.errorJSON(w, r, err, http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	game := patchedGame(current, payload)
+
+	err = app.db(r).PatchGame(r.Context(), current, game)
+	if errors.Is(err, models.ErrEditConflict) {
+		app.errorJSON(w, r, err, http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", gameETag(game.Version))
+
+	type jsonResp struct {
+		OK bool `json:"ok"`
+	}
+
+	ok := jsonResp{
+		OK: true,
+	}
+	app.writeOK(w, r, ok)
+}
+
+// patchedGame returns the current game with the writable fields of payload. The fields a patch cannot
+// write, like the cover, keep their current value, so they are not recorded as changed
+func patchedGame(current *models.Game, payload gamePayload) *models.Game {
+	game := &models.Game{
+		ID:          current.ID,
+		Title:       payload.Title,
+		ImageUrl:    current.ImageUrl,
+		Genres:      make(map[int]string),
+		Modes:       make(map[int]string),
+		Developers:  payload.Developers,
+		Publishers:  payload.Publishers,
+		ReleaseDate: payload.ReleaseDate,
+		Storage:     payload.Storage,
+		Likes:       payload.Likes,
+	}
+	for _, genreID := range payload.Genres {
+		game.Genres[genreID] = ""
+	}
+	for _, modeID := range payload.Modes {
+		game.Modes[modeID] = ""
+	}
+
+	return game
+}
+
+// invalidPatchError is returned when the patch applies but the game it leaves is not valid
+type invalidPatchError struct {
+	err error
+}
+
+func (e *invalidPatchError) Error() string {
+	return e.err.Error()
+}
+
+func (e *invalidPatchError) Unwrap() error {
+	return e.err
+}
+
+// patchPayload applies the patch to the writable fields of the current game, and decodes the result as
+// strictly as a JSON body, so a patch cannot touch fields such as id or version
+func patchPayload(current *models.Game, mediaType string, patch []byte) (gamePayload, error) {
+	var payload gamePayload
+
+	original, err := json.Marshal(payloadFromGame(current))
+	if err != nil {
+		return payload, err
+	}
+
+	patched, err := applyPatch(mediaType, original, patch)
+	if err != nil {
+		return payload, err
+	}
+
+	// el resultado se decodifica igual de estricto que un cuerpo JSON
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&payload)
+	if err != nil {
+		return payload, &invalidPatchError{fmt.Errorf("patched game is not valid: %w", err)}
+	}
+
+	err = payload.validate()
+	if err != nil {
+		return payload, &invalidPatchError{err}
+	}
+
+	return payload, nil
+}
+
+// applyPatch applies a merge patch or a JSON patch, as told by mediaType, to the original document
+func applyPatch(mediaType string, original, patch []byte) ([]byte, error) {
+	if mediaType == jsonPatchType {
+		operations, err := jsonpatch.DecodePatch(patch)
+		if err != nil {
+			return nil, fmt.Errorf("body is not a valid JSON patch: %w", err)
+		}
+
+		patched, err := operations.Apply(original)
+		if err != nil {
+			return nil, fmt.Errorf("applying JSON patch: %w", err)
+		}
+
+		return patched, nil
+	}
+
+	var object map[string]interface{}
+	if err := json.Unmarshal(patch, &object); err != nil || object == nil {
+		return nil, errors.New("body must be a JSON object")
+	}
+
+	patched, err := jsonpatch.MergePatch(original, patch)
+	if err != nil {
+		return nil, fmt.Errorf("applying merge patch: %w", err)
+	}
+
+	return patched, nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"CRUDWeb/models"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+func TestPatchPayload(t *testing.T) {
+	current := &models.Game{
+		ID:          7,
+		Title:       "Doom",
+		Genres:      map[int]string{2: "Shooter", 1: "Action"},
+		Modes:       map[int]string{1: "Single player"},
+		Developers:  []string{"id Software"},
+		Publishers:  []string{"GT Interactive"},
+		ReleaseDate: time.Date(1993, time.December, 10, 0, 0, 0, 0, time.UTC),
+		Storage:     40,
+		Likes:       3,
+		Version:     4,
+	}
+	unchanged := payloadFromGame(current)
+
+	// with devuelve el juego actual con los cambios de edit
+	with := func(edit func(p *gamePayload)) gamePayload {
+		p := payloadFromGame(current)
+		edit(&p)
+		return p
+	}
+
+	tests := []struct {
+		name        string
+		mediaType   string
+		patch       string
+		want        gamePayload
+		wantErr     bool
+		wantInvalid bool
+		wantTest    bool
+	}{
+		{
+			name:      "merge patch",
+			mediaType: mergePatchType,
+			patch:     `{"title": "Doom II", "storage": 60}`,
+			want:      with(func(p *gamePayload) { p.Title = "Doom II"; p.Storage = 60 }),
+		},
+		{
+			name:      "merge patch replaces lists",
+			mediaType: mergePatchType,
+			patch:     `{"genres": [3], "developers": ["id Software", "Raven"]}`,
+			want: with(func(p *gamePayload) {
+				p.Genres = []int{3}
+				p.Developers = []string{"id Software", "Raven"}
+			}),
+		},
+		{
+			name:      "merge patch null clears a field",
+			mediaType: mergePatchType,
+			patch:     `{"likes": null}`,
+			want:      with(func(p *gamePayload) { p.Likes = 0 }),
+		},
+		{
+			name:      "empty merge patch",
+			mediaType: mergePatchType,
+			patch:     `{}`,
+			want:      unchanged,
+		},
+		{
+			name:      "plain JSON is a merge patch",
+			mediaType: "application/json",
+			patch:     `{"likes": 4}`,
+			want:      with(func(p *gamePayload) { p.Likes = 4 }),
+		},
+		{
+			name:      "merge patch that is not an object",
+			mediaType: mergePatchType,
+			patch:     `[{"op": "replace", "path": "/title", "value": "Doom II"}]`,
+			wantErr:   true,
+		},
+		{
+			name:      "JSON patch",
+			mediaType: jsonPatchType,
+			patch:     `[{"op": "replace", "path": "/title", "value": "Doom II"}, {"op": "add", "path": "/genres/-", "value": 3}]`,
+			want: with(func(p *gamePayload) {
+				p.Title = "Doom II"
+				p.Genres = []int{1, 2, 3}
+			}),
+		},
+		{
+			name:      "JSON patch removes from a list",
+			mediaType: jsonPatchType,
+			patch:     `[{"op": "remove", "path": "/genres/0"}]`,
+			want:      with(func(p *gamePayload) { p.Genres = []int{2} }),
+		},
+		{
+			name:      "JSON patch with a passing test",
+			mediaType: jsonPatchType,
+			patch:     `[{"op": "test", "path": "/title", "value": "Doom"}, {"op": "replace", "path": "/likes", "value": 5}]`,
+			want:      with(func(p *gamePayload) { p.Likes = 5 }),
+		},
+		{
+			name:      "JSON patch with a failing test",
+			mediaType: jsonPatchType,
+			patch:     `[{"op": "test", "path": "/title", "value": "Quake"}, {"op": "replace", "path": "/likes", "value": 5}]`,
+			wantErr:   true,
+			wantTest:  true,
+		},
+		{
+			name:      "JSON patch that is not a list",
+			mediaType: jsonPatchType,
+			patch:     `{"title": "Doom II"}`,
+			wantErr:   true,
+		},
+		{
+			name:      "JSON patch on a missing path",
+			mediaType: jsonPatchType,
+			patch:     `[{"op": "replace", "path": "/rating", "value": 5}]`,
+			wantErr:   true,
+		},
+		{
+			name:        "merge patch of the id",
+			mediaType:   mergePatchType,
+			patch:       `{"id": 8}`,
+			wantErr:     true,
+			wantInvalid: true,
+		},
+		{
+			name:        "merge patch of the version",
+			mediaType:   mergePatchType,
+			patch:       `{"version": 1}`,
+			wantErr:     true,
+			wantInvalid: true,
+		},
+		{
+			name:        "merge patch of the image",
+			mediaType:   mergePatchType,
+			patch:       `{"image_url": "quake.png"}`,
+			wantErr:     true,
+			wantInvalid: true,
+		},
+		{
+			name:        "JSON patch that adds the id",
+			mediaType:   jsonPatchType,
+			patch:       `[{"op": "add", "path": "/id", "value": 8}]`,
+			wantErr:     true,
+			wantInvalid: true,
+		},
+		{
+			name:        "JSON patch that adds the media",
+			mediaType:   jsonPatchType,
+			patch:       `[{"op": "add", "path": "/media", "value": []}]`,
+			wantErr:     true,
+			wantInvalid: true,
+		},
+		{
+			name:        "patch that leaves an invalid game",
+			mediaType:   mergePatchType,
+			patch:       `{"title": "", "likes": -1}`,
+			wantErr:     true,
+			wantInvalid: true,
+		},
+		{
+			name:        "patch with a value of the wrong type",
+			mediaType:   jsonPatchType,
+			patch:       `[{"op": "replace", "path": "/storage", "value": "40 GB"}]`,
+			wantErr:     true,
+			wantInvalid: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := patchPayload(current, tt.mediaType, []byte(tt.patch))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("patchPayload() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err != nil {
+				var invalid *invalidPatchError
+				if errors.As(err, &invalid) != tt.wantInvalid {
+					t.Errorf("patchPayload() error = %v, want invalid game %t", err, tt.wantInvalid)
+				}
+				if errors.Is(err, jsonpatch.ErrTestFailed) != tt.wantTest {
+					t.Errorf("patchPayload() error = %v, want failed test %t", err, tt.wantTest)
+				}
+				return
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("patchPayload() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatchedGameAudit(t *testing.T) {
+	current := &models.Game{
+		ID:          7,
+		Title:       "Doom",
+		ImageUrl:    "Doom_cover_1.png",
+		Genres:      map[int]string{1: "Action", 2: "Shooter"},
+		Modes:       map[int]string{1: "Single player"},
+		Developers:  []string{"id Software"},
+		Publishers:  []string{"GT Interactive"},
+		ReleaseDate: time.Date(1993, time.December, 10, 0, 0, 0, 0, time.UTC),
+		Storage:     40,
+		Likes:       3,
+		Version:     4,
+	}
+
+	tests := []struct {
+		name       string
+		patch      string
+		wantAction string
+		wantFields []string
+	}{
+		{name: "likes", patch: `{"likes": 4}`, wantAction: models.AuditLike, wantFields: []string{"likes"}},
+		{name: "title", patch: `{"title": "Doom II"}`, wantAction: models.AuditUpdate, wantFields: []string{"title"}},
+		{name: "title and likes", patch: `{"title": "Doom II", "likes": 4}`, wantAction: models.AuditUpdate, wantFields: []string{"likes", "title"}},
+		{name: "genres", patch: `{"genres": [2, 3]}`, wantAction: models.AuditUpdate, wantFields: []string{"genres"}},
+		{name: "same genres in another order", patch: `{"genres": [2, 1]}`, wantAction: models.AuditUpdate, wantFields: []string{}},
+		{name: "nothing", patch: `{}`, wantAction: models.AuditUpdate, wantFields: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := patchPayload(current, mergePatchType, []byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			action, changes := models.GameAuditChanges(current, patchedGame(current, payload))
+			if action != tt.wantAction {
+				t.Errorf("action = %q, want %q", action, tt.wantAction)
+			}
+
+			fields := []string{}
+			for field := range changes {
+				fields = append(fields, field)
+			}
+			sort.Strings(fields)
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("changed fields = %v, want %v", fields, tt.wantFields)
+			}
+		})
+	}
+}
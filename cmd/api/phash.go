@@ -0,0 +1,311 @@
+package main
+
+import (
+	"CRUDWeb/models"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math/bits"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// maxImageSize is the biggest image file accepted on upload
+const maxImageSize = 32 << 20
+
+// maxImagePixels is the biggest width x height accepted on upload. The dimensions are checked before
+// decoding, as a small file can declare a huge image that would take gigabytes to decode
+const maxImagePixels = 50_000_000
+
+// imageFormats are the image formats accepted on upload, with the extension the files are stored with
+var imageFormats = map[string]string{
+	"jpeg": ".jpg",
+	"png":  ".png",
+	"gif":  ".gif",
+}
+
+// Errors of the image uploads
+var (
+	errInvalidImage  = errors.New("the image must be a JPEG, PNG or GIF file")
+	errImageTooLarge = fmt.Errorf("the image must not be larger than %d bytes", maxImageSize)
+	errImageTooBig   = fmt.Errorf("the image must not have more than %d pixels", maxImagePixels)
+)
+
+// imageUpload is an image read from a multipart request, already decoded
+type imageUpload struct {
+	data   []byte
+	format string
+	img    image.Image
+}
+
+// coverUpload is a cover image read from a multipart request
+type coverUpload struct {
+	data       []byte
+	format     string
+	hash       uint64
+	duplicates []int
+}
+
+// duplicateCoverError is returned when a cover is rejected for being too similar to the cover of other games
+type duplicateCoverError struct {
+	gameIDs []int
+}
+
+func (e *duplicateCoverError) Error() string {
+	return fmt.Sprintf("the image looks like the cover of game(s) %v", e.gameIDs)
+}
+
+// coverPair are two games with similar covers
+type coverPair struct {
+	GameID      int `json:"game_id"`
+	OtherGameID int `json:"other_game_id"`
+	Distance    int `json:"distance"`
+}
+
+// imageHash computes the difference hash (dHash) of an image: the image is reduced to 9x8 grey pixels and
+// every bit tells whether a pixel is brighter than its right neighbour. Similar images give hashes
+// with a small hamming distance, even after resizing or recompressing
+func imageHash(img image.Image) (uint64, error) {
+	bounds := img.Bounds()
+	if bounds.Dx() == 0 || bounds.Dy() == 0 {
+		return 0, fmt.Errorf("empty image")
+	}
+
+	const width, height = 9, 8
+	var grey [height][width]float64
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := bounds.Min.Y + (y+1)*bounds.Dy()/height
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := bounds.Min.X + (x+1)*bounds.Dx()/width
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+
+			// media del bloque de pixeles en escala de grises
+			var sum float64
+			for py := y0; py < y1; py++ {
+				for px := x0; px < x1; px++ {
+					r, g, b, _ := img.At(px, py).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+				}
+			}
+			grey[y][x] = sum / float64((y1-y0)*(x1-x0))
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width-1; x++ {
+			hash <<= 1
+			if grey[y][x] > grey[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash, nil
+}
+
+// hashDistance is the number of different bits between two hashes
+func hashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// readImage reads the "image" part of a multipart request and checks that it is an image in one of
+// imageFormats, returning errInvalidImage if it is not, and errImageTooLarge or errImageTooBig if it is over
+// the limits. It returns http.ErrMissingFile if the request has no image
+func (app *application) readImage(r *http.Request) (*imageUpload, error) {
+	r.ParseMultipartForm(maxImageSize)
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// se lee un byte de mas para saber si el archivo pasa del limite
+	data, err := io.ReadAll(io.LimitReader(file, maxImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxImageSize {
+		return nil, errImageTooLarge
+	}
+
+	// el formato sale del contenido, nunca del nombre que manda el cliente
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		app.log(r).Warn("cannot decode uploaded image", "error", err)
+		return nil, errInvalidImage
+	}
+	if _, ok := imageFormats[format]; !ok {
+		return nil, errInvalidImage
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, errInvalidImage
+	}
+	if int64(config.Width)*int64(config.Height) > maxImagePixels {
+		return nil, errImageTooBig
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		app.log(r).Warn("cannot decode uploaded image", "error", err)
+		return nil, errInvalidImage
+	}
+
+	return &imageUpload{data: data, format: format, img: img}, nil
+}
+
+// readCover reads the "image" part of a multipart request with readImage and looks for games other than
+// gameID with a similar cover. It returns the errors of readImage, and a *duplicateCoverError if duplicates
+// are configured to be rejected
+func (app *application) readCover(r *http.Request, gameID int) (*coverUpload, error) {
+	upload, err := app.readImage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := imageHash(upload.img)
+	if err != nil {
+		return nil, errInvalidImage
+	}
+	cover := &coverUpload{data: upload.data, format: upload.format, hash: hash}
+
+	hashes, err := app.db(r).GetAllImageHashes(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	for id, other := range hashes {
+		if id != gameID && hashDistance(hash, other) <= app.config.images.dupThreshold {
+			cover.duplicates = append(cover.duplicates, id)
+		}
+	}
+	sort.Ints(cover.duplicates)
+
+	if len(cover.duplicates) > 0 && app.config.images.dupReject {
+		return nil, &duplicateCoverError{gameIDs: cover.duplicates}
+	}
+
+	return cover, nil
+}
+
+// coverName is a new file name for the cover of the game title, with the extension of its format.
+// Every upload gets its own file, so a cover is never replaced on disk before its game points to the new one
+func coverName(title string, cover *coverUpload) string {
+	return fmt.Sprintf("%s_cover_%d%s", title, time.Now().UnixNano(), imageFormats[cover.format])
+}
+
+// saveCover writes the cover to the store as name
+func (app *application) saveCover(ctx context.Context, name string, cover *coverUpload) error {
+	return app.images.Save(ctx, name, bytes.NewReader(cover.data))
+}
+
+// removeUnusedCover removes the previous cover of a game once the game points to its new one,
+// unless some other game or media item still uses the file
+func (app *application) removeUnusedCover(r *http.Request, name string) {
+	if name == "" {
+		return
+	}
+
+	referenced, err := app.db(r).IsImageReferenced(r.Context(), name)
+	if err != nil {
+		app.log(r).Error("checking previous cover", "error", err, "file", name)
+		return
+	}
+	if referenced {
+		return
+	}
+
+	err = app.images.Remove(r.Context(), name)
+	if err != nil {
+		app.log(r).Error("removing previous cover", "error", err, "file", name)
+	}
+}
+
+// saveCoverHash stores the hash and the checksum of the cover of a game, and warns the client about similar covers
+func (app *application) saveCoverHash(w http.ResponseWriter, r *http.Request, gameID int, cover *coverUpload) error {
+	for _, id := range cover.duplicates {
+		w.Header().Add("Warning", fmt.Sprintf(`199 - "image looks like the cover of game %d"`, id))
+	}
+
+	// la suma del manifiesto se calcula aqui, con la imagen ya en memoria
+	sum := sha256.Sum256(cover.data)
+	checksum := &models.ImageChecksum{
+		SHA256: hex.EncodeToString(sum[:]),
+		Size:   int64(len(cover.data)),
+	}
+
+	return app.db(r).SetGameImageHash(r.Context(), gameID, &cover.hash, checksum)
+}
+
+// uploadError writes the error of an image upload, with 409 for rejected duplicates, 413 for images over
+// the limits and 415 for files that are not an accepted image
+func (app *application) uploadError(w http.ResponseWriter, r *http.Request, err error) {
+	if _, ok := err.(*duplicateCoverError); ok {
+		app.errorJSON(w, r, err, http.StatusConflict)
+		return
+	}
+	switch err {
+	case errInvalidImage:
+		app.errorJSON(w, r, err, http.StatusUnsupportedMediaType)
+		return
+	case errImageTooLarge, errImageTooBig:
+		app.errorJSON(w, r, err, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	app.errorJSON(w, r, err)
+}
+
+// getDuplicateImages handles /v1/admin/images/duplicates
+// lists the pairs of games whose covers are within ?threshold= bits of each other
+func (app *application) getDuplicateImages(w http.ResponseWriter, r *http.Request) {
+	threshold := app.config.images.dupThreshold
+	if t := r.URL.Query().Get("threshold"); t != "" {
+		var err error
+		threshold, err = strconv.Atoi(t)
+		if err != nil {
+			app.errorJSON(w, r, err)
+			return
+		}
+	}
+
+	hashes, err := app.db(r).GetAllImageHashes(r.Context())
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	ids := make([]int, 0, len(hashes))
+	for id := range hashes {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	pairs := []coverPair{}
+	for i, id := range ids {
+		for _, other := range ids[i+1:] {
+			distance := hashDistance(hashes[id], hashes[other])
+			if distance <= threshold {
+				pairs = append(pairs, coverPair{GameID: id, OtherGameID: other, Distance: distance})
+			}
+		}
+	}
+
+	app.writeJSON(w, http.StatusOK, pairs, "duplicates")
+}
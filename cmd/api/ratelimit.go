@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimitBudget is the token bucket given to each client on a class of routes:
+// burst requests at once, refilled at rate requests per second
+type rateLimitBudget struct {
+	name  string
+	rate  float64
+	burst int
+}
+
+// rateLimitResult is the state of a bucket after taking a token
+type rateLimitResult struct {
+	allowed    bool
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+// rateLimitStore keeps the buckets of every client. The in-process store is enough for a single
+// instance; several instances behind a load balancer need a shared implementation (e.g. redis)
+type rateLimitStore interface {
+	Take(key string, budget rateLimitBudget, now time.Time) (rateLimitResult, error)
+	// Peek returns the state of a bucket as Take would find it, without taking a token
+	Peek(key string, budget rateLimitBudget, now time.Time) (rateLimitResult, error)
+}
+
+// rateLimiter picks the budget of each request and applies it through the store
+type rateLimiter struct {
+	store      rateLimitStore
+	trustProxy bool
+	standard   rateLimitBudget
+	write      rateLimitBudget
+	heavy      rateLimitBudget
+	// auth is spent by the failed authentications of each ip, before the budget of the client is known
+	auth rateLimitBudget
+	// routes with their own budget, by path
+	routes map[string]rateLimitBudget
+}
+
+func newRateLimiter(cfg config, store rateLimitStore) (*rateLimiter, error) {
+	limiter := &rateLimiter{
+		store:      store,
+		trustProxy: cfg.limiter.trustProxy,
+		standard:   rateLimitBudget{name: "default", rate: cfg.limiter.rps, burst: cfg.limiter.burst},
+		write:      rateLimitBudget{name: "write", rate: cfg.limiter.writeRPS, burst: cfg.limiter.writeBurst},
+		heavy:      rateLimitBudget{name: "heavy", rate: cfg.limiter.heavyRPS, burst: cfg.limiter.heavyBurst},
+		auth:       rateLimitBudget{name: "auth", rate: cfg.limiter.authRPS, burst: cfg.limiter.authBurst},
+	}
+
+	// estas rutas leen todas las imagenes del disco
+	limiter.routes = map[string]rateLimitBudget{
+		"/v1/games/images":          limiter.heavy,
+		"/v1/games/images/manifest": limiter.heavy,
+		"/v2/images":                limiter.heavy,
+		"/v2/images/manifest":       limiter.heavy,
+	}
+
+	for _, budget := range []rateLimitBudget{limiter.standard, limiter.write, limiter.heavy, limiter.auth} {
+		if budget.rate <= 0 || budget.burst < 1 {
+			return nil, fmt.Errorf("rate limit %s: rate must be positive and burst at least 1", budget.name)
+		}
+	}
+
+	return limiter, nil
+}
+
+// budgetFor returns the budget of a request: the route's own, the write one for non-GET methods, or the default
+func (l *rateLimiter) budgetFor(r *http.Request) rateLimitBudget {
+	if budget, ok := l.routes[r.URL.Path]; ok {
+		return budget
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return l.standard
+	default:
+		return l.write
+	}
+}
+
+// clientKey identifies the client of a request: its api key, its user or its ip
+func (l *rateLimiter) clientKey(r *http.Request) string {
+	if p := principalFromContext(r.Context()); p != nil {
+		if p.APIKeyID != 0 {
+			return fmt.Sprintf("key:%d", p.APIKeyID)
+		}
+		if p.UserID != 0 {
+			return fmt.Sprintf("user:%d", p.UserID)
+		}
+	}
+
+	return l.ipKey(r)
+}
+
+// ipKey identifies the client of a request by its ip
+func (l *rateLimiter) ipKey(r *http.Request) string {
+	if l.trustProxy {
+		// la ultima entrada es la que ha añadido nuestro proxy, las anteriores las controla el cliente
+		forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+		if ip := strings.TrimSpace(forwarded[len(forwarded)-1]); ip != "" {
+			return "ip:" + ip
+		}
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	return "ip:" + ip
+}
+
+// rateLimit rejects with 429 the requests of clients that have spent their budget
+func (app *application) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.limiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		budget := app.limiter.budgetFor(r)
+		result, err := app.limiter.store.Take(budget.name+"/"+app.limiter.clientKey(r), budget, time.Now())
+		if err != nil {
+			// si el almacen falla se deja pasar la peticion antes que tirar la api
+			app.log(r).Error("rate limit store", "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(budget.burst))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.reset)))
+		w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", budget.burst, ceilSeconds(time.Duration(float64(budget.burst)/budget.rate*float64(time.Second)))))
+
+		if !result.allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.retryAfter)))
+			app.errorJSON(w, r, fmt.Errorf("rate limit exceeded, try again later"), http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tooManyAuthFailures answers 429 to the requests with credentials from an ip that has spent its budget
+// of failed authentications, before checking the credentials costs a database lookup
+func (app *application) tooManyAuthFailures(w http.ResponseWriter, r *http.Request) bool {
+	if app.limiter == nil {
+		return false
+	}
+
+	budget := app.limiter.auth
+	result, err := app.limiter.store.Peek(budget.name+"/"+app.limiter.ipKey(r), budget, time.Now())
+	if err != nil {
+		app.log(r).Error("rate limit store", "error", err)
+		return false
+	}
+	if result.allowed {
+		return false
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.retryAfter)))
+	app.errorJSON(w, r, fmt.Errorf("too many failed authentications, try again later"), http.StatusTooManyRequests)
+	return true
+}
+
+// countAuthFailure spends a token of the failed authentications budget of the ip of the request
+func (app *application) countAuthFailure(r *http.Request) {
+	if app.limiter == nil {
+		return
+	}
+
+	budget := app.limiter.auth
+	_, err := app.limiter.store.Take(budget.name+"/"+app.limiter.ipKey(r), budget, time.Now())
+	if err != nil {
+		app.log(r).Error("rate limit store", "error", err)
+	}
+}
+
+// ceilSeconds rounds d up to whole seconds
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// memoryRateLimitStore keeps the buckets in memory
+type memoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	// full is when the bucket will be full again if the client makes no more requests
+	full time.Time
+}
+
+// newMemoryRateLimitStore returns an in-process store that forgets idle clients every cleanupInterval
+func newMemoryRateLimitStore(cleanupInterval time.Duration) *memoryRateLimitStore {
+	s := &memoryRateLimitStore{buckets: make(map[string]*tokenBucket)}
+
+	go func() {
+		for range time.Tick(cleanupInterval) {
+			s.cleanup(time.Now())
+		}
+	}()
+
+	return s
+}
+
+// Take takes a token from the bucket of key, refilling it first for the time elapsed since the last request
+func (s *memoryRateLimitStore) Take(key string, budget rateLimitBudget, now time.Time) (rateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(budget.burst), last: now}
+		s.buckets[key] = bucket
+	}
+
+	bucket.tokens = bucket.refilled(budget, now)
+	bucket.last = now
+
+	taken := bucket.tokens >= 1
+	if taken {
+		bucket.tokens--
+	}
+
+	result := bucketResult(budget, bucket.tokens, taken)
+	bucket.full = now.Add(result.reset)
+
+	return result, nil
+}
+
+// Peek returns the state of the bucket of key, refilled up to now, without taking a token
+func (s *memoryRateLimitStore) Peek(key string, budget rateLimitBudget, now time.Time) (rateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens := float64(budget.burst)
+	if bucket, ok := s.buckets[key]; ok {
+		tokens = bucket.refilled(budget, now)
+	}
+
+	return bucketResult(budget, tokens, tokens >= 1), nil
+}
+
+// refilled returns the tokens of the bucket after refilling it for the time elapsed since the last request
+func (b *tokenBucket) refilled(budget rateLimitBudget, now time.Time) float64 {
+	return math.Min(float64(budget.burst), b.tokens+now.Sub(b.last).Seconds()*budget.rate)
+}
+
+// bucketResult describes a bucket left with tokens; if the request was not allowed, when to retry
+func bucketResult(budget rateLimitBudget, tokens float64, allowed bool) rateLimitResult {
+	result := rateLimitResult{allowed: allowed}
+	if !allowed {
+		result.retryAfter = time.Duration((1 - tokens) / budget.rate * float64(time.Second))
+	}
+
+	result.remaining = int(tokens)
+	result.reset = time.Duration((float64(budget.burst) - tokens) / budget.rate * float64(time.Second))
+
+	return result
+}
+
+// cleanup removes the buckets that are full again: a new bucket would be the same
+func (s *memoryRateLimitStore) cleanup(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, bucket := range s.buckets {
+		if now.After(bucket.full) {
+			delete(s.buckets, key)
+		}
+	}
+}
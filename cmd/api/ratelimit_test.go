@@ -0,0 +1,526 @@
+package main
+
+import (
+	"CRUDWeb/models"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testLimiterConfig is a config with small budgets, easy to spend in a test
+func testLimiterConfig() config {
+	var cfg config
+	cfg.limiter.rps = 1
+	cfg.limiter.burst = 3
+	cfg.limiter.writeRPS = 0.5
+	cfg.limiter.writeBurst = 2
+	cfg.limiter.heavyRPS = 0.1
+	cfg.limiter.heavyBurst = 1
+	cfg.limiter.authRPS = 0.1
+	cfg.limiter.authBurst = 2
+	return cfg
+}
+
+// failingRateLimitStore is a store that is down
+type failingRateLimitStore struct{}
+
+func (failingRateLimitStore) Take(string, rateLimitBudget, time.Time) (rateLimitResult, error) {
+	return rateLimitResult{}, errors.New("store is down")
+}
+
+func (failingRateLimitStore) Peek(string, rateLimitBudget, time.Time) (rateLimitResult, error) {
+	return rateLimitResult{}, errors.New("store is down")
+}
+
+func TestMemoryRateLimitStoreTake(t *testing.T) {
+	budget := rateLimitBudget{name: "test", rate: 1, burst: 2}
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	type take struct {
+		key           string
+		after         time.Duration
+		wantAllowed   bool
+		wantRemaining int
+		wantRetry     time.Duration
+		wantReset     time.Duration
+	}
+
+	tests := []struct {
+		name  string
+		takes []take
+	}{
+		{
+			name: "burst then rejected",
+			takes: []take{
+				{after: 0, wantAllowed: true, wantRemaining: 1, wantReset: time.Second},
+				{after: 0, wantAllowed: true, wantRemaining: 0, wantReset: 2 * time.Second},
+				{after: 0, wantAllowed: false, wantRemaining: 0, wantRetry: time.Second, wantReset: 2 * time.Second},
+			},
+		},
+		{
+			name: "refilled with time",
+			takes: []take{
+				{after: 0, wantAllowed: true, wantRemaining: 1, wantReset: time.Second},
+				{after: 0, wantAllowed: true, wantRemaining: 0, wantReset: 2 * time.Second},
+				{after: 500 * time.Millisecond, wantAllowed: false, wantRemaining: 0, wantRetry: 500 * time.Millisecond, wantReset: 1500 * time.Millisecond},
+				{after: time.Second, wantAllowed: true, wantRemaining: 0, wantReset: 1500 * time.Millisecond},
+			},
+		},
+		{
+			name: "never above the burst",
+			takes: []take{
+				{after: 0, wantAllowed: true, wantRemaining: 1, wantReset: time.Second},
+				{after: time.Hour, wantAllowed: true, wantRemaining: 1, wantReset: time.Second},
+			},
+		},
+		{
+			name: "clients do not share buckets",
+			takes: []take{
+				{key: "a", after: 0, wantAllowed: true, wantRemaining: 1, wantReset: time.Second},
+				{key: "a", after: 0, wantAllowed: true, wantRemaining: 0, wantReset: 2 * time.Second},
+				{key: "b", after: 0, wantAllowed: true, wantRemaining: 1, wantReset: time.Second},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &memoryRateLimitStore{buckets: make(map[string]*tokenBucket)}
+			now := start
+			for i, tk := range tt.takes {
+				now = now.Add(tk.after)
+				key := tk.key
+				if key == "" {
+					key = "client"
+				}
+
+				got, err := store.Take(key, budget, now)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := rateLimitResult{allowed: tk.wantAllowed, remaining: tk.wantRemaining, reset: tk.wantReset, retryAfter: tk.wantRetry}
+				if got != want {
+					t.Errorf("take %d = %+v, want %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryRateLimitStorePeek(t *testing.T) {
+	budget := rateLimitBudget{name: "test", rate: 1, burst: 2}
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	store := &memoryRateLimitStore{buckets: make(map[string]*tokenBucket)}
+
+	tests := []struct {
+		name string
+		take bool
+		at   time.Duration
+		want rateLimitResult
+	}{
+		{name: "unknown client", want: rateLimitResult{allowed: true, remaining: 2}},
+		{name: "after a take", take: true, want: rateLimitResult{allowed: true, remaining: 1, reset: time.Second}},
+		{name: "empty", take: true, want: rateLimitResult{allowed: false, remaining: 0, reset: 2 * time.Second, retryAfter: time.Second}},
+		{name: "peeking takes nothing", want: rateLimitResult{allowed: false, remaining: 0, reset: 2 * time.Second, retryAfter: time.Second}},
+		{name: "refilled", at: 1500 * time.Millisecond, want: rateLimitResult{allowed: true, remaining: 1, reset: 500 * time.Millisecond}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.take {
+				store.Take("client", budget, start)
+			}
+
+			got, err := store.Peek("client", budget, start.Add(tt.at))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("peek = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryRateLimitStoreCleanup(t *testing.T) {
+	budget := rateLimitBudget{name: "test", rate: 1, burst: 2}
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	store := &memoryRateLimitStore{buckets: make(map[string]*tokenBucket)}
+	store.Take("idle", budget, start)
+	store.Take("busy", budget, start.Add(2*time.Second))
+	store.Take("busy", budget, start.Add(2*time.Second))
+
+	// idle esta lleno desde start+1s, busy no lo estara hasta start+4s
+	store.cleanup(start.Add(3 * time.Second))
+
+	if _, ok := store.buckets["idle"]; ok {
+		t.Error("the full bucket was kept")
+	}
+	if _, ok := store.buckets["busy"]; !ok {
+		t.Error("the bucket still refilling was removed")
+	}
+}
+
+func TestNewRateLimiter(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(*config)
+		wantErr bool
+	}{
+		{name: "valid", change: func(*config) {}},
+		{name: "zero rate", change: func(cfg *config) { cfg.limiter.rps = 0 }, wantErr: true},
+		{name: "negative write rate", change: func(cfg *config) { cfg.limiter.writeRPS = -1 }, wantErr: true},
+		{name: "zero heavy burst", change: func(cfg *config) { cfg.limiter.heavyBurst = 0 }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testLimiterConfig()
+			tt.change(&cfg)
+
+			_, err := newRateLimiter(cfg, failingRateLimitStore{})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRateLimiterBudgetFor(t *testing.T) {
+	limiter, err := newRateLimiter(testLimiterConfig(), failingRateLimitStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{http.MethodGet, "/v1/games", "default"},
+		{http.MethodHead, "/v1/games", "default"},
+		{http.MethodOptions, "/v1/games", "default"},
+		{http.MethodPost, "/v1/games", "write"},
+		{http.MethodPut, "/v2/games/1", "write"},
+		{http.MethodPatch, "/v2/games/1", "write"},
+		{http.MethodDelete, "/v2/games/1", "write"},
+		{http.MethodGet, "/v1/games/images", "heavy"},
+		{http.MethodGet, "/v2/images/manifest", "heavy"},
+		{http.MethodGet, "/v2/images/abc.jpg", "default"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if got := limiter.budgetFor(r).name; got != tt.want {
+				t.Errorf("budget = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiterClientKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		trustProxy bool
+		principal  *principal
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{name: "ip", remoteAddr: "192.0.2.1:1234", want: "ip:192.0.2.1"},
+		{name: "ipv6", remoteAddr: "[2001:db8::1]:1234", want: "ip:2001:db8::1"},
+		{name: "address without port", remoteAddr: "192.0.2.1", want: "ip:192.0.2.1"},
+		{name: "forwarded ignored without proxy", remoteAddr: "192.0.2.1:1234", forwarded: "198.51.100.7", want: "ip:192.0.2.1"},
+		{name: "forwarded by the proxy", trustProxy: true, remoteAddr: "10.0.0.1:1234", forwarded: "198.51.100.7", want: "ip:198.51.100.7"},
+		{name: "forged forwarded entries", trustProxy: true, remoteAddr: "10.0.0.1:1234", forwarded: "203.0.113.9, 198.51.100.7", want: "ip:198.51.100.7"},
+		{name: "proxy without forwarded", trustProxy: true, remoteAddr: "10.0.0.1:1234", want: "ip:10.0.0.1"},
+		{name: "user", principal: &principal{Subject: "ana", UserID: 7}, remoteAddr: "192.0.2.1:1234", want: "user:7"},
+		{name: "api key before user", principal: &principal{Subject: "ci", UserID: 7, APIKeyID: 3}, remoteAddr: "192.0.2.1:1234", want: "key:3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := &rateLimiter{trustProxy: tt.trustProxy}
+
+			r := httptest.NewRequest(http.MethodGet, "/v1/games", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if tt.principal != nil {
+				r = withPrincipal(r, tt.principal)
+			}
+
+			if got := limiter.clientKey(r); got != tt.want {
+				t.Errorf("key = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	type response struct {
+		status     int
+		remaining  string
+		retryAfter string
+	}
+
+	tests := []struct {
+		name        string
+		store       rateLimitStore
+		method      string
+		want        []response
+		wantLimit   string
+		wantPolicy  string
+		noHeaders   bool
+		noRateLimit bool
+	}{
+		{
+			name:       "reads",
+			method:     http.MethodGet,
+			wantLimit:  "3",
+			wantPolicy: "3;w=3",
+			want: []response{
+				{status: http.StatusOK, remaining: "2"},
+				{status: http.StatusOK, remaining: "1"},
+				{status: http.StatusOK, remaining: "0"},
+				{status: http.StatusTooManyRequests, remaining: "0", retryAfter: "1"},
+			},
+		},
+		{
+			name:       "writes",
+			method:     http.MethodPost,
+			wantLimit:  "2",
+			wantPolicy: "2;w=4",
+			want: []response{
+				{status: http.StatusOK, remaining: "1"},
+				{status: http.StatusOK, remaining: "0"},
+				{status: http.StatusTooManyRequests, remaining: "0", retryAfter: "2"},
+			},
+		},
+		{
+			name:      "store down lets requests through",
+			store:     failingRateLimitStore{},
+			method:    http.MethodGet,
+			noHeaders: true,
+			want: []response{
+				{status: http.StatusOK},
+				{status: http.StatusOK},
+				{status: http.StatusOK},
+				{status: http.StatusOK},
+			},
+		},
+		{
+			name:        "disabled",
+			method:      http.MethodGet,
+			noRateLimit: true,
+			noHeaders:   true,
+			want: []response{
+				{status: http.StatusOK},
+				{status: http.StatusOK},
+				{status: http.StatusOK},
+				{status: http.StatusOK},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+			if !tt.noRateLimit {
+				store := tt.store
+				if store == nil {
+					store = &memoryRateLimitStore{buckets: make(map[string]*tokenBucket)}
+				}
+				var err error
+				app.limiter, err = newRateLimiter(testLimiterConfig(), store)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			handler := app.rateLimit(ok)
+
+			for i, want := range tt.want {
+				r := httptest.NewRequest(tt.method, "/v1/games", nil)
+				r.RemoteAddr = "192.0.2.1:1234"
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, r)
+
+				h := rec.Header()
+				if rec.Code != want.status {
+					t.Errorf("request %d: status = %d, want %d", i, rec.Code, want.status)
+				}
+				if tt.noHeaders {
+					if h.Get("RateLimit-Limit") != "" {
+						t.Errorf("request %d: got RateLimit headers %v", i, h)
+					}
+					continue
+				}
+				if h.Get("RateLimit-Limit") != tt.wantLimit || h.Get("RateLimit-Policy") != tt.wantPolicy {
+					t.Errorf("request %d: limit %q, policy %q, want %q and %q", i, h.Get("RateLimit-Limit"), h.Get("RateLimit-Policy"), tt.wantLimit, tt.wantPolicy)
+				}
+				if h.Get("RateLimit-Remaining") != want.remaining {
+					t.Errorf("request %d: remaining = %q, want %q", i, h.Get("RateLimit-Remaining"), want.remaining)
+				}
+				if h.Get("Retry-After") != want.retryAfter {
+					t.Errorf("request %d: Retry-After = %q, want %q", i, h.Get("Retry-After"), want.retryAfter)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthFailures(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name       string
+		store      rateLimitStore
+		remoteAddr string
+		header     string
+		value      string
+		wantStatus int
+	}{
+		{name: "first failure", remoteAddr: "192.0.2.1:1234", header: "Authorization", value: "Basic YTpi", wantStatus: http.StatusUnauthorized},
+		{name: "second failure", remoteAddr: "192.0.2.1:1234", header: "X-API-Key", value: "ck_unknown", wantStatus: http.StatusUnauthorized},
+		{name: "budget spent", remoteAddr: "192.0.2.1:1234", header: "Authorization", value: "Basic YTpi", wantStatus: http.StatusTooManyRequests},
+		{name: "anonymous from the same ip", remoteAddr: "192.0.2.1:1234", wantStatus: http.StatusOK},
+		{name: "other ip", remoteAddr: "192.0.2.2:1234", header: "Authorization", value: "Basic YTpi", wantStatus: http.StatusUnauthorized},
+		{name: "store down", store: failingRateLimitStore{}, remoteAddr: "192.0.2.1:1234", header: "Authorization", value: "Basic YTpi", wantStatus: http.StatusUnauthorized},
+	}
+
+	memory := &memoryRateLimitStore{buckets: make(map[string]*tokenBucket)}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := tt.store
+			if store == nil {
+				store = memory
+			}
+			limiter, err := newRateLimiter(testLimiterConfig(), store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil)), limiter: limiter}
+
+			// la api key no llega a la base de datos: su prefijo no es valido
+			r := httptest.NewRequest(http.MethodGet, "/v1/games", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			app.authenticate(ok).ServeHTTP(rec, r)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+				t.Error("no Retry-After header")
+			}
+		})
+	}
+}
+
+// memoryUserStore keeps the users in memory, by username
+type memoryUserStore map[string]*models.User
+
+func (s memoryUserStore) GetOneUser(ctx context.Context, id int) (*models.User, error) {
+	for _, user := range s {
+		if user.ID == id {
+			return user, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (s memoryUserStore) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	user, ok := s[username]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	return user, nil
+}
+
+func TestSigninAuthFailures(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("right password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := memoryUserStore{
+		"admin": {ID: 1, Username: "admin", PasswordHash: string(hash), Role: "admin", TokenVersion: 1},
+	}
+
+	var cfg config
+	cfg.jwt.secret = "secret"
+	cfg.jwt.ttl = time.Hour
+	keys, err := newJWTKeySet(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limiter, err := newRateLimiter(testLimiterConfig(), &memoryRateLimitStore{buckets: make(map[string]*tokenBucket)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &application{
+		config:  cfg,
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		limiter: limiter,
+		jwtKeys: keys,
+		users:   users,
+	}
+
+	// los casos comparten el limitador: el presupuesto de fallos se gasta de uno a otro
+	tests := []struct {
+		name       string
+		remoteAddr string
+		username   string
+		password   string
+		wantStatus int
+	}{
+		{name: "right password", remoteAddr: "192.0.2.1:1234", username: "admin", password: "right password", wantStatus: http.StatusOK},
+		{name: "wrong password", remoteAddr: "192.0.2.1:1234", username: "admin", password: "guess 1", wantStatus: http.StatusUnauthorized},
+		{name: "unknown user", remoteAddr: "192.0.2.1:1234", username: "root", password: "guess 2", wantStatus: http.StatusUnauthorized},
+		{name: "budget spent", remoteAddr: "192.0.2.1:1234", username: "admin", password: "guess 3", wantStatus: http.StatusTooManyRequests},
+		{name: "right password with the budget spent", remoteAddr: "192.0.2.1:1234", username: "admin", password: "right password", wantStatus: http.StatusTooManyRequests},
+		{name: "other ip", remoteAddr: "192.0.2.2:1234", username: "admin", password: "guess 4", wantStatus: http.StatusUnauthorized},
+		{name: "right password from another ip", remoteAddr: "192.0.2.3:1234", username: "admin", password: "right password", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"username": "` + tt.username + `", "password": "` + tt.password + `"}`
+			r := httptest.NewRequest(http.MethodPost, "/v1/signin", strings.NewReader(body))
+			r.RemoteAddr = tt.remoteAddr
+			rec := httptest.NewRecorder()
+			app.signin(rec, r)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+				t.Error("no Retry-After header")
+			}
+		})
+	}
+}
@@ -1,28 +1,61 @@
 package main
 
-import (
-	"net/http"
-
-	"github.com/julienschmidt/httprouter"
-)
+import "net/http"
 
 func (app *application) routes() http.Handler {
-	router := httprouter.New()
+	router := newRouter()
 
 	router.HandlerFunc(http.MethodGet, "/status", app.statusHandler)
+	router.HandlerFunc(http.MethodGet, "/healthz", app.healthz)
+	router.HandlerFunc(http.MethodGet, "/readyz", app.readyz)
+
+	router.HandlerFunc(http.MethodPost, "/v1/signin", app.signin)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me", app.requirePermission(permReadGames, app.getCurrentUser))
+	router.HandlerFunc(http.MethodGet, "/v1/users", app.requirePermission(permManageUsers, app.getAllUsers))
+	router.HandlerFunc(http.MethodPut, "/v1/users/insert", app.requirePermission(permManageUsers, app.insertUser))
+	router.HandlerFunc(http.MethodPut, "/v1/users/update/:id", app.requirePermission(permManageUsers, app.updateUser))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/delete/:id", app.requirePermission(permManageUsers, app.deleteUser))
 
 	router.HandlerFunc(http.MethodGet, "/v1/genres", app.getAllGenres)
 	router.HandlerFunc(http.MethodGet, "/v1/modes", app.getAllModes)
+	router.HandlerFunc(http.MethodPut, "/v1/genres/insert", app.requirePermission(permManageTaxonomy, app.insertGenre))
+	router.HandlerFunc(http.MethodPut, "/v1/genres/update/:id", app.requirePermission(permManageTaxonomy, app.updateGenre))
+	router.HandlerFunc(http.MethodDelete, "/v1/genres/delete/:id", app.requirePermission(permManageTaxonomy, app.deleteGenre))
+	router.HandlerFunc(http.MethodPut, "/v1/modes/insert", app.requirePermission(permManageTaxonomy, app.insertMode))
+	router.HandlerFunc(http.MethodPut, "/v1/modes/update/:id", app.requirePermission(permManageTaxonomy, app.updateMode))
+	router.HandlerFunc(http.MethodDelete, "/v1/modes/delete/:id", app.requirePermission(permManageTaxonomy, app.deleteMode))
 
 	router.HandlerFunc(http.MethodGet, "/v1/games", app.getAllGames)
 	router.HandlerFunc(http.MethodGet, "/v1/game/:id", app.getOneGame)
 	router.HandlerFunc(http.MethodGet, "/v1/game/:id/image", app.getGameImage)
 	router.HandlerFunc(http.MethodGet, "/v1/games/images", app.getAllImages)
+	router.HandlerFunc(http.MethodGet, "/v1/games/images/manifest", app.getImagesManifest)
 	router.HandlerFunc(http.MethodGet, "/v1/games/genre/:genre", app.getAllGamesByGenre)
 
-	router.HandlerFunc(http.MethodPut, "/v1/games/insert", app.insertGame)
-	router.HandlerFunc(http.MethodPut, "/v1/games/update/:id", app.updateGame)
-	router.HandlerFunc(http.MethodDelete, "/v1/games/delete/:id", app.deleteGame)
-
-	return app.enableCORS(router)
+	router.HandlerFunc(http.MethodPut, "/v1/games/insert", app.requirePermission(permWriteGames, app.insertGame))
+	router.HandlerFunc(http.MethodPut, "/v1/games/update/:id", app.requirePermission(permWriteGames, app.updateGame))
+	router.HandlerFunc(http.MethodPatch, "/v1/game/:id", app.requirePermission(permWriteGames, app.patchGame))
+	router.HandlerFunc(http.MethodPost, "/v1/game/:id/restore", app.requirePermission(permDeleteGames, app.restoreGame))
+	router.HandlerFunc(http.MethodDelete, "/v1/games/delete/:id", app.requirePermission(permDeleteGames, app.deleteGame))
+	router.HandlerFunc(http.MethodPut, "/v1/game/:id/image", app.requirePermission(permWriteGames, app.uploadGameImage))
+	router.HandlerFunc(http.MethodDelete, "/v1/game/:id/image", app.requirePermission(permWriteGames, app.deleteGameImage))
+
+	router.HandlerFunc(http.MethodGet, "/v1/game/:id/media", app.getGameMedia)
+	router.HandlerFunc(http.MethodGet, "/v1/game/:id/media/:media", app.getMediaImage)
+	router.HandlerFunc(http.MethodPut, "/v1/game/:id/media", app.requirePermission(permWriteGames, app.insertMedia))
+	router.HandlerFunc(http.MethodPut, "/v1/game/:id/media/:media", app.requirePermission(permWriteGames, app.updateMedia))
+	router.HandlerFunc(http.MethodDelete, "/v1/game/:id/media/:media", app.requirePermission(permWriteGames, app.deleteMedia))
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/status", app.requireAdmin(app.getDetailedStatus))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/images/duplicates", app.requireAdmin(app.getDuplicateImages))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/trash", app.requireAdmin(app.getTrashedGames))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/audit", app.requireAdmin(app.getAuditLog))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/apikeys", app.requireAdmin(app.getAllAPIKeys))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/apikeys/insert", app.requireAdmin(app.insertAPIKey))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/apikeys/update/:id", app.requireAdmin(app.updateAPIKey))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/apikeys/revoke/:id", app.requireAdmin(app.revokeAPIKey))
+
+	app.routesV2(router)
+
+	return app.requestID(app.logRequest(app.instrument(app.traceRequest(app.enableCORS(app.authenticate(app.rateLimit(app.deprecateV1(app.idempotency(router)))))))))
 }
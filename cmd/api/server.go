@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// serve runs the http server until SIGINT or SIGTERM. Then /readyz fails for the shutdown delay while
+// requests are still served, and the server stops accepting requests and waits up to the shutdown
+// timeout for the ones in flight. Background jobs are stopped before returning.
+// With a certificate configured it serves https (and HTTP/2), reloading the certificate on SIGHUP
+// or when its files change, and optionally redirects plain http to it. /metrics is served on its own port
+func (app *application) serve() error {
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%d", app.config.port),
+		Handler:      app.routes(),
+		IdleTimeout:  app.config.http.idleTimeout,
+		ReadTimeout:  app.config.http.readTimeout,
+		WriteTimeout: app.config.http.writeTimeout,
+		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelWarn),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var jobs sync.WaitGroup
+	if app.config.images.gcInterval > 0 {
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			app.imagesGCJob(ctx, app.config.images.gcInterval, app.config.images.gcDelete)
+		}()
+	}
+
+	if app.config.trash.retention > 0 && app.config.trash.purgeInterval > 0 {
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			app.purgeTrashJob(ctx, app.config.trash.purgeInterval, app.config.trash.retention)
+		}()
+	}
+
+	if app.config.idempotency.ttl > 0 {
+		// las claves caducadas se borran al menos una vez por hora
+		interval := time.Hour
+		if app.config.idempotency.ttl < interval {
+			interval = app.config.idempotency.ttl
+		}
+
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			app.idempotencyCleanupJob(ctx, interval)
+		}()
+	}
+
+	servers := []*http.Server{srv}
+	if app.config.tlsEnabled() {
+		certs, err := newCertReloader(app.config.tls.certFile, app.config.tls.keyFile)
+		if err != nil {
+			return err
+		}
+
+		srv.TLSConfig, err = newTLSConfig(app.config, certs)
+		if err != nil {
+			return err
+		}
+		if !app.config.tls.http2 {
+			// un mapa vacio (no nil) evita que net/http active HTTP/2 por su cuenta
+			srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		}
+
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			app.reloadOnHangup(ctx, certs)
+		}()
+
+		if app.config.tls.reloadInterval > 0 {
+			jobs.Add(1)
+			go func() {
+				defer jobs.Done()
+				app.watchCertificates(ctx, certs, app.config.tls.reloadInterval)
+			}()
+		}
+
+		if app.config.tls.redirectPort > 0 {
+			redirect := app.redirectServer(app.config.tls.redirectPort)
+			servers = append(servers, redirect)
+			go func() {
+				app.logger.Info("redirecting http to https", "port", app.config.tls.redirectPort)
+				err := redirect.ListenAndServe()
+				if !errors.Is(err, http.ErrServerClosed) {
+					app.logger.Error("http redirect server", "error", err)
+				}
+			}()
+		}
+	}
+
+	if app.config.metrics.port > 0 {
+		metrics := app.metricsServer(app.config.metrics.port)
+		servers = append(servers, metrics)
+		go func() {
+			app.logger.Info("serving metrics", "port", app.config.metrics.port)
+			err := metrics.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.Error("metrics server", "error", err)
+			}
+		}()
+	}
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		stop()
+
+		// /readyz empieza a fallar y se sigue atendiendo durante shutdown-delay, hasta que el balanceador
+		// lo note y deje de mandar trafico. Una segunda señal corta la espera y mata el proceso
+		atomic.StoreInt32(&app.shuttingDown, 1)
+		if app.config.shutdownDelay > 0 {
+			app.logger.Info("draining before shutdown", "delay", app.config.shutdownDelay)
+			time.Sleep(app.config.shutdownDelay)
+		}
+		app.logger.Info("shutting down server", "timeout", app.config.shutdownTimeout)
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), app.config.shutdownTimeout)
+		defer cancel()
+
+		var err error
+		for _, s := range servers {
+			if e := s.Shutdown(shutdownCtx); e != nil {
+				err = e
+			}
+		}
+		shutdownErr <- err
+	}()
+
+	app.logger.Info("starting server", "port", app.config.port, "env", app.config.env, "tls", app.config.tlsEnabled())
+
+	var err error
+	if app.config.tlsEnabled() {
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	err = <-shutdownErr
+	if err != nil {
+		return err
+	}
+
+	jobs.Wait()
+	app.logger.Info("stopped server")
+
+	return nil
+}
+
+// reloadOnHangup reloads the certificate every time the process gets SIGHUP, until ctx is done
+func (app *application) reloadOnHangup(ctx context.Context, certs *certReloader) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			app.reloadCertificates(certs)
+		}
+	}
+}
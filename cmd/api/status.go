@@ -0,0 +1,140 @@
+package main
+
+import (
+	"CRUDWeb/models"
+	"context"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// poolStatus are the connection pool stats of sql.DB
+type poolStatus struct {
+	MaxOpenConnections int    `json:"max_open_connections"`
+	OpenConnections    int    `json:"open_connections"`
+	InUse              int    `json:"in_use"`
+	Idle               int    `json:"idle"`
+	WaitCount          int64  `json:"wait_count"`
+	WaitDuration       string `json:"wait_duration"`
+	MaxIdleClosed      int64  `json:"max_idle_closed"`
+	MaxIdleTimeClosed  int64  `json:"max_idle_time_closed"`
+	MaxLifetimeClosed  int64  `json:"max_lifetime_closed"`
+}
+
+// imagesStatus tells whether the image store works and how much it holds
+type imagesStatus struct {
+	Writable bool   `json:"writable"`
+	Error    string `json:"error,omitempty"`
+	Files    int    `json:"files"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// detailedStatus is the status shown to admins
+type detailedStatus struct {
+	AppStatus
+	Database      *models.DBStatus `json:"database,omitempty"`
+	DatabaseError string           `json:"database_error,omitempty"`
+	Pool          poolStatus       `json:"pool"`
+	Images        imagesStatus     `json:"images"`
+}
+
+// buildInfo returns the commit and build time given with -ldflags, or else the ones stamped by the go tool
+func buildInfo() (string, string) {
+	rev, built := commit, buildTime
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && rev == "":
+				rev = setting.Value
+			case setting.Key == "vcs.time" && built == "":
+				built = setting.Value
+			}
+		}
+	}
+
+	if rev == "" {
+		rev = "unknown"
+	}
+	if built == "" {
+		built = "unknown"
+	}
+
+	return rev, built
+}
+
+// appStatus returns the public status of the api
+func (app *application) appStatus() AppStatus {
+	rev, built := buildInfo()
+
+	return AppStatus{
+		Status:      "Available",
+		Environment: app.config.env,
+		Version:     version,
+		Commit:      rev,
+		BuildTime:   built,
+		GoVersion:   runtime.Version(),
+		Uptime:      time.Since(app.startedAt).Round(time.Second).String(),
+	}
+}
+
+// imagesStatus checks the image store and counts its files
+func (app *application) imagesStatus(ctx context.Context) imagesStatus {
+	var status imagesStatus
+
+	err := app.images.Writable(ctx)
+	if err != nil {
+		status.Error = err.Error()
+	} else {
+		status.Writable = true
+	}
+
+	files, err := app.images.List(ctx)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Files = len(files)
+	for _, file := range files {
+		status.Bytes += file.Size()
+	}
+
+	return status
+}
+
+// getDetailedStatus handles /v1/admin/status
+// adds the database, its connection pool and the image store to the public status
+func (app *application) getDetailedStatus(w http.ResponseWriter, r *http.Request) {
+	stats := app.models.DB.DB.Stats()
+
+	status := detailedStatus{
+		AppStatus: app.appStatus(),
+		Pool: poolStatus{
+			MaxOpenConnections: stats.MaxOpenConnections,
+			OpenConnections:    stats.OpenConnections,
+			InUse:              stats.InUse,
+			Idle:               stats.Idle,
+			WaitCount:          stats.WaitCount,
+			WaitDuration:       stats.WaitDuration.String(),
+			MaxIdleClosed:      stats.MaxIdleClosed,
+			MaxIdleTimeClosed:  stats.MaxIdleTimeClosed,
+			MaxLifetimeClosed:  stats.MaxLifetimeClosed,
+		},
+		Images: app.imagesStatus(r.Context()),
+	}
+
+	// si la base de datos no responde se informa igualmente del resto
+	db, err := app.db(r).GetDBStatus(r.Context())
+	if err != nil {
+		app.log(r).Error("reading database status", "error", err)
+		status.Status = "Degraded"
+		status.DatabaseError = err.Error()
+	} else {
+		status.Database = db
+	}
+	if !status.Images.Writable {
+		status.Status = "Degraded"
+	}
+
+	app.writeJSON(w, http.StatusOK, status, "status")
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type taxonomyPayload struct {
+	Name string `json:"name"`
+}
+
+// readTaxonomyPayload decodes the name of a genre or mode from the request body
+func readTaxonomyPayload(r *http.Request) (string, error) {
+	var payload taxonomyPayload
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		return "", err
+	}
+
+	name := strings.TrimSpace(payload.Name)
+	if name == "" {
+		return "", errors.New("name is required")
+	}
+
+	return name, nil
+}
+
+// insertGenre handles /v1/genres/insert
+func (app *application) insertGenre(w http.ResponseWriter, r *http.Request) {
+	name, err := readTaxonomyPayload(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	id, err := app.db(r).InsertGenre(r.Context(), name)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, map[int]string{id: name}, "genre")
+}
+
+// updateGenre handles /v1/genres/update/:id
+func (app *application) updateGenre(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.log(r).Warn("invalid id parameter", "error", err)
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	name, err := readTaxonomyPayload(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	err = app.db(r).UpdateGenre(r.Context(), id, name)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, map[int]string{id: name}, "genre")
+}
+
+// deleteGenre handles /v1/genres/delete/:id
+func (app *application) deleteGenre(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.log(r).Warn("invalid id parameter", "error", err)
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	err = app.db(r).DeleteGenre(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	type jsonResp struct {
+		OK bool `json:"ok"`
+	}
+
+	ok := jsonResp{
+		OK: true,
+	}
+	app.writeJSON(w, http.StatusOK, ok, "OK")
+}
+
+// insertMode handles /v1/modes/insert
+func (app *application) insertMode(w http.ResponseWriter, r *http.Request) {
+	name, err := readTaxonomyPayload(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	id, err := app.db(r).InsertMode(r.Context(), name)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, map[int]string{id: name}, "mode")
+}
+
+// updateMode handles /v1/modes/update/:id
+func (app *application) updateMode(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.log(r).Warn("invalid id parameter", "error", err)
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	name, err := readTaxonomyPayload(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	err = app.db(r).UpdateMode(r.Context(), id, name)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, map[int]string{id: name}, "mode")
+}
+
+// deleteMode handles /v1/modes/delete/:id
+func (app *application) deleteMode(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.log(r).Warn("invalid id parameter", "error", err)
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	err = app.db(r).DeleteMode(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	type jsonResp struct {
+		OK bool `json:"ok"`
+	}
+
+	ok := jsonResp{
+		OK: true,
+	}
+	app.writeJSON(w, http.StatusOK, ok, "OK")
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// certReloader serves the certificate of the server and replaces it when the files change, so
+// renewed certificates are picked up without a restart. For local testing a self-signed pair can be made with
+//
+//	go run $(go env GOROOT)/src/crypto/tls/generate_cert.go -host localhost
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// newCertReloader loads the certificate and key from their files
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile}
+
+	err := cr.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return cr, nil
+}
+
+// Reload reads the files again. The current certificate is kept if they are not valid
+func (cr *certReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading certificate: %w", err)
+	}
+
+	modTime, err := cr.lastModified()
+	if err != nil {
+		return err
+	}
+
+	cr.mu.Lock()
+	cr.cert = &cert
+	cr.modTime = modTime
+	cr.mu.Unlock()
+
+	return nil
+}
+
+// lastModified is the latest modification time of the certificate and key files
+func (cr *certReloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{cr.certFile, cr.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// changed tells whether the files were modified since they were loaded
+func (cr *certReloader) changed() bool {
+	modTime, err := cr.lastModified()
+	if err != nil {
+		return false
+	}
+
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+
+	return modTime.After(cr.modTime)
+}
+
+// GetCertificate is the tls.Config callback that returns the current certificate
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+
+	return cr.cert, nil
+}
+
+// tlsEnabled tells whether the server must serve https
+func (cfg config) tlsEnabled() bool {
+	return cfg.tls.certFile != "" || cfg.tls.keyFile != ""
+}
+
+// newTLSConfig returns the tls configuration of the server. If a client CA is given, clients may present
+// a certificate signed by it, which requireClientCert demands on the routes of admin permissions
+func newTLSConfig(cfg config, certs *certReloader) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+	if !cfg.tls.http2 {
+		tlsConfig.NextProtos = []string{"http/1.1"}
+	}
+
+	if cfg.tls.clientCA != "" {
+		pem, err := os.ReadFile(cfg.tls.clientCA)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", cfg.tls.clientCA)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return tlsConfig, nil
+}
+
+// watchCertificates reloads the certificate when the files change, checking every interval, until ctx is done
+func (app *application) watchCertificates(ctx context.Context, certs *certReloader, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if certs.changed() {
+			app.reloadCertificates(certs)
+		}
+	}
+}
+
+// reloadCertificates loads the certificate again, logging the result
+func (app *application) reloadCertificates(certs *certReloader) {
+	err := certs.Reload()
+	if err != nil {
+		app.logger.Error("reloading tls certificate, keeping the current one", "error", err)
+		return
+	}
+
+	app.logger.Info("reloaded tls certificate", "cert", certs.certFile)
+}
+
+// redirectServer answers plain http requests on port with a permanent redirect to the https server
+func (app *application) redirectServer(port int) *http.Server {
+	return &http.Server{
+		Addr:         fmt.Sprintf(":%d", port),
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+		IdleTimeout:  app.config.http.idleTimeout,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host, _, err := net.SplitHostPort(r.Host)
+			if err != nil {
+				host = r.Host
+			}
+			if app.config.port != 443 {
+				host = net.JoinHostPort(host, strconv.Itoa(app.config.port))
+			}
+
+			http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+		}),
+	}
+}
+
+// requireClientCert rejects requests without a client certificate signed by the configured client CA.
+// It does nothing when mutual tls is not configured
+func (app *application) requireClientCert(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.config.tls.clientCA == "" {
+			next(w, r)
+			return
+		}
+
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			app.errorJSON(w, r, errors.New("a client certificate is required"), http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// requireAdmin protects the admin routes: admin permission, and a client certificate if mutual tls is configured
+func (app *application) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return app.requirePermission(permAdmin, next)
+}
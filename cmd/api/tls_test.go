@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate made for a test, with its key
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCert makes a certificate from template, signed by parent, or self-signed if parent is nil
+func newTestCert(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// newTestCA makes a self-signed CA
+func newTestCA(t *testing.T, name string) *testCert {
+	return newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, nil)
+}
+
+// tlsCertificate returns the certificate as the tls package wants it
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+// writePEM writes the certificate, and its key if keyFile is not empty, as PEM files
+func (c *testCert) writePEM(t *testing.T, certFile, keyFile string) {
+	t.Helper()
+
+	err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyFile == "" {
+		return
+	}
+
+	der, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRequireClientCert(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCA(t, "CRUDWeb test CA")
+	otherCA := newTestCA(t, "other CA")
+
+	server := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		DNSNames:    []string{"localhost"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	server.writePEM(t, filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem"))
+	ca.writePEM(t, filepath.Join(dir, "ca.pem"), "")
+
+	clientTemplate := func() *x509.Certificate {
+		return &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "admin"},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+	}
+	client := newTestCert(t, clientTemplate(), ca)
+	stranger := newTestCert(t, clientTemplate(), otherCA)
+
+	var cfg config
+	cfg.tls.certFile = filepath.Join(dir, "server.pem")
+	cfg.tls.keyFile = filepath.Join(dir, "server-key.pem")
+	cfg.tls.clientCA = filepath.Join(dir, "ca.pem")
+
+	certs, err := newCertReloader(cfg.tls.certFile, cfg.tls.keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig, err := newTLSConfig(cfg, certs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &application{
+		config: cfg,
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin", app.requireAdmin(ok))
+	mux.HandleFunc("/users", app.requirePermission(permManageUsers, ok))
+	mux.HandleFunc("/genres", app.requirePermission(permManageTaxonomy, ok))
+	mux.HandleFunc("/restore", app.requirePermission(permDeleteGames, ok))
+	mux.HandleFunc("/games", app.requirePermission(permWriteGames, ok))
+
+	// las peticiones llegan ya autenticadas como admin: aqui solo se mira el certificado
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.ServeHTTP(w, withPrincipal(r, &principal{Subject: "admin", UserID: 1, Role: "admin"}))
+	})
+
+	srv := httptest.NewUnstartedServer(handler)
+	srv.TLS = tlsConfig
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	tests := []struct {
+		name       string
+		path       string
+		clientCert *testCert
+		wantStatus int
+		wantError  bool
+	}{
+		{name: "admin route without certificate", path: "/admin", wantStatus: http.StatusForbidden},
+		{name: "users without certificate", path: "/users", wantStatus: http.StatusForbidden},
+		{name: "genres without certificate", path: "/genres", wantStatus: http.StatusForbidden},
+		{name: "restore without certificate", path: "/restore", wantStatus: http.StatusForbidden},
+		{name: "editor route without certificate", path: "/games", wantStatus: http.StatusOK},
+		{name: "admin route with certificate", path: "/admin", clientCert: client, wantStatus: http.StatusOK},
+		{name: "users with certificate", path: "/users", clientCert: client, wantStatus: http.StatusOK},
+		{name: "restore with certificate", path: "/restore", clientCert: client, wantStatus: http.StatusOK},
+		{name: "certificate of another CA", path: "/admin", clientCert: stranger, wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// con ServerName el servidor elige su certificado con GetCertificate, no el de httptest
+			clientConfig := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+			if tt.clientCert != nil {
+				// el certificado se envia siempre, aunque el servidor no acepte a quien lo firma
+				cert := tt.clientCert.tlsCertificate()
+				clientConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+					return &cert, nil
+				}
+			}
+			httpClient := &http.Client{
+				Transport: &http.Transport{TLSClientConfig: clientConfig},
+				Timeout:   5 * time.Second,
+			}
+			defer httpClient.CloseIdleConnections()
+
+			resp, err := httpClient.Get(srv.URL + tt.path)
+			if tt.wantError {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatalf("got status %d, want the handshake to fail", resp.StatusCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name of the spans of the api
+const tracerName = "CRUDWeb"
+
+// newTracerProvider returns a provider that sends the sampled spans to exporter.
+// Tests can pass a tracetest.InMemoryExporter to look at the spans of a request
+func newTracerProvider(cfg config, exporter sdktrace.SpanExporter) *sdktrace.TracerProvider {
+	res := resource.NewWithAttributes("",
+		attribute.String("service.name", "CRUDWeb"),
+		attribute.String("service.version", version),
+		attribute.String("deployment.environment", cfg.env),
+	)
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.tracing.sampleRatio))),
+	)
+}
+
+// newOTLPExporter returns an exporter sending spans over OTLP/HTTP to the configured collector.
+// The standard OTEL_EXPORTER_OTLP_* variables are honoured too
+func newOTLPExporter(cfg config) (sdktrace.SpanExporter, error) {
+	opts := []otlptracehttp.Option{}
+	if cfg.tracing.endpoint != "" {
+		opts = append(opts, otlptracehttp.WithEndpoint(cfg.tracing.endpoint))
+	}
+	if cfg.tracing.insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return otlptracehttp.New(ctx, opts...)
+}
+
+// tracingEnabled tells whether spans should be exported, either by flag or by the standard environment variables
+func tracingEnabled(cfg config) bool {
+	return cfg.tracing.endpoint != "" ||
+		os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" ||
+		os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// endSpan records err, if any, and ends the span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceRequest starts the server span of the request, continuing the trace of the client if it sent a
+// traceparent header, and adds the trace id to the log lines of the request
+func (app *application) traceRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := app.tracer.Start(ctx, "HTTP "+r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.method", r.Method),
+				attribute.String("http.target", r.URL.RequestURI()),
+				attribute.String("http.user_agent", r.UserAgent()),
+				attribute.String("net.peer.addr", r.RemoteAddr),
+				attribute.String("http.request_id", requestIDFromContext(r.Context())),
+			),
+		)
+		defer span.End()
+
+		if sc := span.SpanContext(); sc.IsValid() {
+			ctx = context.WithValue(ctx, loggerContextKey, app.log(r).With("trace_id", sc.TraceID().String()))
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok && info.route != "" {
+			span.SetName(r.Method + " " + info.route)
+			span.SetAttributes(attribute.String("http.route", info.route))
+		}
+		span.SetAttributes(attribute.Int("http.status_code", rec.status))
+		if rec.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"CRUDWeb/models"
+	"context"
+	"database/sql"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// spanAttribute returns the value of the attribute key of span, or "" if it does not have it
+func spanAttribute(span tracetest.SpanStub, key attribute.Key) string {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+
+	return ""
+}
+
+func TestTraceRequest(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer tp.Shutdown(context.Background())
+
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	// nadie escucha en el puerto 1: la consulta falla enseguida, pero su span se registra igual
+	db, err := sql.Open("postgres", "postgres://test@127.0.0.1:1/test?sslmode=disable&connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	app := &application{
+		logger:  logger,
+		models:  models.NewModels(db, logger),
+		metrics: newMetrics(db),
+		tracer:  tp.Tracer(tracerName),
+	}
+	app.models.DB.Tracer = tp.Tracer(tracerName + "/models")
+
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/game/4242", nil)
+	req.Header.Set("traceparent", traceparent)
+	rec := httptest.NewRecorder()
+	app.routes().ServeHTTP(rec, req)
+
+	var server, query *tracetest.SpanStub
+	spans := exporter.GetSpans()
+	for i := range spans {
+		switch spans[i].SpanKind {
+		case trace.SpanKindServer:
+			server = &spans[i]
+		case trace.SpanKindClient:
+			if query == nil {
+				query = &spans[i]
+			}
+		}
+	}
+	if server == nil || query == nil {
+		t.Fatalf("got spans %v, want a server span and a database span", spans)
+	}
+
+	t.Run("server span", func(t *testing.T) {
+		if server.Name != "GET /v1/game/:id" {
+			t.Errorf("name = %q, want %q", server.Name, "GET /v1/game/:id")
+		}
+		if got := server.SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("trace id = %s, want the one of the traceparent header", got)
+		}
+
+		tests := []struct {
+			key  attribute.Key
+			want string
+		}{
+			{"http.method", http.MethodGet},
+			{"http.route", "/v1/game/:id"},
+			{"http.target", "/v1/game/4242"},
+			{"http.status_code", "500"},
+		}
+		for _, tt := range tests {
+			if got := spanAttribute(*server, tt.key); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.key, got, tt.want)
+			}
+		}
+	})
+
+	t.Run("database span", func(t *testing.T) {
+		if query.Parent.SpanID() != server.SpanContext.SpanID() {
+			t.Errorf("parent = %s, want the server span %s", query.Parent.SpanID(), server.SpanContext.SpanID())
+		}
+		if query.Name != "SELECT" {
+			t.Errorf("name = %q, want SELECT", query.Name)
+		}
+		if got := spanAttribute(*query, "db.system"); got != "postgresql" {
+			t.Errorf("db.system = %q, want postgresql", got)
+		}
+		if len(query.Events) == 0 {
+			t.Error("the error of the query was not recorded")
+		}
+
+		statement := spanAttribute(*query, "db.statement")
+		if !strings.Contains(statement, "$1") || strings.Contains(statement, "4242") {
+			t.Errorf("db.statement = %q, want the values as placeholders", statement)
+		}
+	})
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// trashReport is the result of a purge of the trash
+type trashReport struct {
+	Before  time.Time `json:"before"`
+	Removed []string  `json:"removed"`
+	Kept    []string  `json:"kept"`
+}
+
+// trashStore purges the games of the trash and lists the images still in use
+type trashStore interface {
+	imageReferenceStore
+	PurgeDeletedGames(ctx context.Context, before time.Time) ([]string, error)
+}
+
+// trashStore returns the store of the purge of the trash
+func (app *application) trashStore() trashStore {
+	if app.trash != nil {
+		return app.trash
+	}
+
+	return &app.models.DB
+}
+
+// restoreGame handles POST /v1/game/:id/restore and POST /v2/games/:id/restore
+func (app *application) restoreGame(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.log(r).Warn("invalid id parameter", "error", err)
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	err = app.db(r).RestoreGame(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, r, errors.New("the game is not in the trash"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	type jsonResp struct {
+		OK bool `json:"ok"`
+	}
+
+	ok := jsonResp{
+		OK: true,
+	}
+	app.writeOK(w, r, ok)
+}
+
+// getTrashedGames handles /v1/admin/trash
+// lists the deleted games and when each one will be purged
+func (app *application) getTrashedGames(w http.ResponseWriter, r *http.Request) {
+	games, err := app.db(r).GetTrashedGames(r.Context())
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	type trashedGame struct {
+		ID        int        `json:"id"`
+		Title     string     `json:"title"`
+		DeletedAt time.Time  `json:"deleted_at"`
+		PurgeAt   *time.Time `json:"purge_at,omitempty"`
+	}
+
+	trash := []trashedGame{}
+	for _, game := range games {
+		item := trashedGame{
+			ID:        game.ID,
+			Title:     game.Title,
+			DeletedAt: *game.DeletedAt,
+		}
+		if app.config.trash.retention > 0 {
+			purgeAt := game.DeletedAt.Add(app.config.trash.retention)
+			item.PurgeAt = &purgeAt
+		}
+		trash = append(trash, item)
+	}
+
+	app.writeJSON(w, http.StatusOK, trash, "trash")
+}
+
+// purgeTrash permanently deletes the games that have been in the trash longer than retention, and
+// removes their images unless another game still uses them. A retention of zero keeps the games
+// forever, so it is an error
+func (app *application) purgeTrash(ctx context.Context, retention time.Duration) (*trashReport, error) {
+	if retention <= 0 {
+		return nil, errors.New("the trash retention must be positive, games are kept forever otherwise")
+	}
+
+	report := &trashReport{
+		Before:  time.Now().Add(-retention),
+		Removed: []string{},
+		Kept:    []string{},
+	}
+
+	files, err := app.trashStore().PurgeDeletedGames(ctx, report.Before)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return report, nil
+	}
+
+	// un mismo fichero puede estar en uso por otro juego (mismo titulo)
+	inUse := make(map[string]bool)
+	images, err := app.trashStore().GetReferencedImages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, image := range images {
+		inUse[image] = true
+	}
+	media, err := app.trashStore().GetAllMediaFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range media {
+		inUse[file] = true
+	}
+
+	for _, file := range files {
+		if inUse[file] {
+			report.Kept = append(report.Kept, file)
+			continue
+		}
+
+		err := app.images.Remove(ctx, file)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			app.logger.Error("purging trash: removing image", "file", file, "error", err)
+			continue
+		}
+		report.Removed = append(report.Removed, file)
+	}
+
+	return report, nil
+}
+
+// runTrashPurge runs "trash purge", printing the report as JSON
+func (app *application) runTrashPurge(args []string) error {
+	fs := flag.NewFlagSet("trash purge", flag.ExitOnError)
+	retention := fs.Duration("retention", app.config.trash.retention, "Purge the games deleted longer ago than this")
+	fs.Parse(args)
+
+	report, err := app.purgeTrash(context.Background(), *retention)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "\t")
+
+	return enc.Encode(report)
+}
+
+// purgeTrashJob purges the trash every interval until ctx is done
+func (app *application) purgeTrashJob(ctx context.Context, interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		spanCtx, span := app.tracer.Start(context.Background(), "trash purge")
+		report, err := app.purgeTrash(spanCtx, retention)
+		endSpan(span, err)
+		if err != nil {
+			app.logger.Error("trash purge", "error", err)
+			continue
+		}
+
+		if len(report.Removed) > 0 || len(report.Kept) > 0 {
+			app.logger.Info("trash purge", "removed", len(report.Removed), "kept", len(report.Kept))
+		}
+	}
+}
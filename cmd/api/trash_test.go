@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// memoryGame is a game of memoryTrash, with its cover and media files
+type memoryGame struct {
+	cover     string
+	media     map[int]string
+	deletedAt *time.Time
+}
+
+// memoryTrash keeps the games in memory, by id
+type memoryTrash struct {
+	games map[int]*memoryGame
+}
+
+func (s *memoryTrash) PurgeDeletedGames(ctx context.Context, before time.Time) ([]string, error) {
+	var files []string
+	for id, game := range s.games {
+		if game.deletedAt == nil || !game.deletedAt.Before(before) {
+			continue
+		}
+		if game.cover != "" {
+			files = append(files, game.cover)
+		}
+		for _, file := range game.media {
+			files = append(files, file)
+		}
+		delete(s.games, id)
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
+
+func (s *memoryTrash) GetReferencedImages(ctx context.Context) (map[int]string, error) {
+	covers := make(map[int]string)
+	for id, game := range s.games {
+		covers[id] = game.cover
+	}
+
+	return covers, nil
+}
+
+func (s *memoryTrash) GetAllMediaFiles(ctx context.Context) (map[int]string, error) {
+	media := make(map[int]string)
+	for _, game := range s.games {
+		for id, file := range game.media {
+			media[id] = file
+		}
+	}
+
+	return media, nil
+}
+
+func TestPurgeTrash(t *testing.T) {
+	now := time.Now()
+	deleted := func(ago time.Duration) *time.Time {
+		at := now.Add(-ago)
+		return &at
+	}
+	day := 24 * time.Hour
+
+	tests := []struct {
+		name        string
+		retention   time.Duration
+		wantErr     bool
+		wantGames   []int
+		wantRemoved []string
+		wantKept    []string
+	}{
+		{
+			name:        "thirty days",
+			retention:   30 * day,
+			wantGames:   []int{2, 3, 4},
+			wantRemoved: []string{"Doom_cover.png", "Doom_media_1.png"},
+			wantKept:    []string{"Shared_media.png"},
+		},
+		{
+			name:        "one day",
+			retention:   day,
+			wantGames:   []int{4},
+			wantRemoved: []string{"Doom_cover.png", "Doom_media_1.png", "Quake_cover.png"},
+			wantKept:    []string{"Shared_cover.png", "Shared_media.png"},
+		},
+		{
+			name:        "longer than any game in the trash",
+			retention:   365 * day,
+			wantGames:   []int{1, 2, 3, 4},
+			wantRemoved: []string{},
+			wantKept:    []string{},
+		},
+		{name: "no retention", retention: 0, wantErr: true, wantGames: []int{1, 2, 3, 4}},
+		{name: "negative retention", retention: -day, wantErr: true, wantGames: []int{1, 2, 3, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trash := &memoryTrash{games: map[int]*memoryGame{
+				// un fichero de la galeria del juego 1 y la portada del 3 los usa tambien el 4, que no esta en la papelera
+				1: {cover: "Doom_cover.png", media: map[int]string{1: "Doom_media_1.png", 2: "Shared_media.png"}, deletedAt: deleted(31 * day)},
+				2: {cover: "Quake_cover.png", deletedAt: deleted(29 * day)},
+				3: {cover: "Shared_cover.png", deletedAt: deleted(10 * day)},
+				4: {cover: "Shared_cover.png", media: map[int]string{3: "Shared_media.png"}},
+			}}
+
+			dir := t.TempDir()
+			for _, name := range []string{"Doom_cover.png", "Doom_media_1.png", "Shared_media.png", "Quake_cover.png", "Shared_cover.png"} {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte("image"), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			app := &application{
+				logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+				images: &imageStore{dir: dir},
+				trash:  trash,
+			}
+
+			report, err := app.purgeTrash(context.Background(), tt.retention)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("purgeTrash() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			games := []int{}
+			for id := range trash.games {
+				games = append(games, id)
+			}
+			sort.Ints(games)
+			if !reflect.DeepEqual(games, tt.wantGames) {
+				t.Errorf("games left = %v, want %v", games, tt.wantGames)
+			}
+			if err != nil {
+				return
+			}
+
+			if cutoff := now.Add(-tt.retention); report.Before.Sub(cutoff).Abs() > time.Minute {
+				t.Errorf("before = %v, want about %v", report.Before, cutoff)
+			}
+			if !reflect.DeepEqual(report.Removed, tt.wantRemoved) {
+				t.Errorf("removed = %v, want %v", report.Removed, tt.wantRemoved)
+			}
+			sort.Strings(report.Kept)
+			if !reflect.DeepEqual(report.Kept, tt.wantKept) {
+				t.Errorf("kept = %v, want %v", report.Kept, tt.wantKept)
+			}
+
+			for _, name := range report.Removed {
+				if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+					t.Errorf("%s is still in the store", name)
+				}
+			}
+			for _, name := range report.Kept {
+				if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+					t.Errorf("%s was removed: %v", name, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"CRUDWeb/models"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// minPasswordLength is the shortest password accepted for a user
+const minPasswordLength = 8
+
+// userPasswordEnv is the variable read by "users create" for the password, so it is not on the command line
+const userPasswordEnv = "CRUDWEB_USER_PASSWORD"
+
+type userPayload struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+// applyTo validates the payload and copies it into user. An empty password keeps the current one
+func (p *userPayload) applyTo(user *models.User) error {
+	if p.Username == "" {
+		return errors.New("username is required")
+	}
+	if _, ok := roles[p.Role]; !ok {
+		return fmt.Errorf("invalid role %q", p.Role)
+	}
+	if p.Password == "" && user.PasswordHash == "" {
+		return errors.New("password is required")
+	}
+
+	if p.Password != "" {
+		if len(p.Password) < minPasswordLength {
+			return fmt.Errorf("password must have at least %d characters", minPasswordLength)
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(p.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		user.PasswordHash = string(hash)
+	}
+
+	user.Username = p.Username
+	user.Role = p.Role
+
+	return nil
+}
+
+// getAllUsers handles /v1/users
+func (app *application) getAllUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := app.db(r).GetAllUsers(r.Context())
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, users, "users")
+}
+
+// getCurrentUser handles /v1/users/me
+// answers the user of the token, or the api key of the request for machine clients
+func (app *application) getCurrentUser(w http.ResponseWriter, r *http.Request) {
+	p := principalFromContext(r.Context())
+
+	if p.APIKeyID != 0 {
+		key, err := app.db(r).GetOneAPIKey(r.Context(), p.APIKeyID)
+		if err != nil {
+			app.errorJSON(w, r, err)
+			return
+		}
+
+		app.writeJSON(w, http.StatusOK, key, "api_key")
+		return
+	}
+
+	if p.UserID == 0 {
+		app.errorJSON(w, r, errors.New("the client of the request is not a user"), http.StatusNotFound)
+		return
+	}
+
+	user, err := app.db(r).GetOneUser(r.Context(), p.UserID)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, user, "user")
+}
+
+// insertUser handles /v1/users/insert
+func (app *application) insertUser(w http.ResponseWriter, r *http.Request) {
+	var payload userPayload
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	var user models.User
+	err = payload.applyTo(&user)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	err = app.db(r).InsertUser(r.Context(), &user)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, user, "user")
+}
+
+// updateUser handles /v1/users/update/:id
+func (app *application) updateUser(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.log(r).Warn("invalid id parameter", "error", err)
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	var payload userPayload
+
+	err = json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	user, err := app.db(r).GetOneUser(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	err = payload.applyTo(user)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	err = app.db(r).UpdateUser(r.Context(), user)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, user, "user")
+}
+
+// deleteUser handles /v1/users/delete/:id
+func (app *application) deleteUser(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.log(r).Warn("invalid id parameter", "error", err)
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	if id == principalFromContext(r.Context()).UserID {
+		app.errorJSON(w, r, errors.New("you cannot delete your own user"))
+		return
+	}
+
+	err = app.db(r).DeleteUser(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	type jsonResp struct {
+		OK bool `json:"ok"`
+	}
+
+	ok := jsonResp{
+		OK: true,
+	}
+	app.writeJSON(w, http.StatusOK, ok, "OK")
+}
+
+// runUsersCreate handles the "users create" command, used to create the first admin.
+// The password is read from $CRUDWEB_USER_PASSWORD or, if it is not set, from the first line of stdin
+func (app *application) runUsersCreate(args []string) error {
+	var payload userPayload
+
+	fs := flag.NewFlagSet("users create", flag.ExitOnError)
+	fs.StringVar(&payload.Username, "username", "", "Username of the new user")
+	fs.StringVar(&payload.Role, "role", "admin", "Role of the new user (viewer|editor|admin)")
+	fs.Parse(args)
+
+	password, err := readPassword(os.Stdin)
+	if err != nil {
+		return err
+	}
+	payload.Password = password
+
+	var user models.User
+	err = payload.applyTo(&user)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.DB.InsertUser(context.Background(), &user)
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("created user", "username", user.Username, "user_id", user.ID, "role", user.Role)
+
+	return nil
+}
+
+// readPassword returns the password of $CRUDWEB_USER_PASSWORD, or the first line of r if it is not set
+func readPassword(r io.Reader) (string, error) {
+	// no hay flag -password: quedaria a la vista en ps y en el historial de la shell
+	if password, ok := os.LookupEnv(userPasswordEnv); ok {
+		return password, nil
+	}
+
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReadPassword(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   *string
+		stdin string
+		want  string
+	}{
+		{name: "line", stdin: "s3cret-password\nnext line\n", want: "s3cret-password"},
+		{name: "windows line", stdin: "s3cret-password\r\n", want: "s3cret-password"},
+		{name: "without newline", stdin: "s3cret-password", want: "s3cret-password"},
+		{name: "spaces are kept", stdin: " s3cret password \n", want: " s3cret password "},
+		{name: "empty stdin", stdin: "", want: ""},
+		{name: "environment first", env: strPtr("from-env"), stdin: "from-stdin\n", want: "from-env"},
+		{name: "empty environment", env: strPtr(""), stdin: "from-stdin\n", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// t.Setenv devuelve la variable a su valor al terminar, tambien cuando se borra
+			t.Setenv(userPasswordEnv, "")
+			if tt.env == nil {
+				os.Unsetenv(userPasswordEnv)
+			} else {
+				os.Setenv(userPasswordEnv, *tt.env)
+			}
+
+			got, err := readPassword(strings.NewReader(tt.stdin))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("password = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
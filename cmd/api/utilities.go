@@ -1,10 +1,23 @@
 package main
 
 import (
+	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
 	"net/http"
+	"strings"
+
+	"github.com/lib/pq"
 )
 
+// maxJSONBodySize is the biggest JSON body accepted
+const maxJSONBodySize = 1 << 20
+
 func (app *application) writeJSON(w http.ResponseWriter, status int, data interface{}, wrap string) error {
 	wrapper := make(map[string]interface{})
 
@@ -22,14 +35,109 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data interf
 	return nil
 }
 
-func (app *application) errorJSON(w http.ResponseWriter, err error) {
+// errorJSON writes err as the error of the response, with status or 400. Rows not found are answered
+// with 404, and the errors of the database are logged, with the request id and route of r, and replaced by a generic message
+func (app *application) errorJSON(w http.ResponseWriter, r *http.Request, err error, status ...int) {
+	statusCode := http.StatusBadRequest
+	if len(status) > 0 {
+		statusCode = status[0]
+	}
+	message := err.Error()
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows) && len(status) == 0:
+		statusCode = http.StatusNotFound
+		message = "the requested resource could not be found"
+	case isConstraintError(err, "23505"):
+		statusCode = http.StatusConflict
+		message = "the resource already exists"
+	case isConstraintError(err, "23503"):
+		statusCode = http.StatusUnprocessableEntity
+		message = "the request refers to a resource that does not exist"
+	case isDatabaseError(err):
+		// los errores del driver no se envian al cliente: pueden llevar sql, datos o direcciones internas
+		route := ""
+		if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+			route = info.route
+		}
+		app.log(r).Error("database error", "error", err, "method", r.Method, "route", route)
+		if statusCode < http.StatusInternalServerError {
+			statusCode = http.StatusInternalServerError
+		}
+		message = "the server could not process the request"
+	}
+
 	type jsonError struct {
 		Message string `json:"message"`
 	}
 
 	theError := jsonError{
-		Message: err.Error(),
+		Message: message,
 	}
 
-	app.writeJSON(w, http.StatusBadRequest, theError, "error")
+	app.writeJSON(w, statusCode, theError, "error")
+}
+
+// isConstraintError tells whether err is a postgres error with code, like 23505 for unique violations
+func isConstraintError(err error, code pq.ErrorCode) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == code
+}
+
+// isDatabaseError tells whether err comes from the database or the connection to it
+func isDatabaseError(err error) bool {
+	var pqErr *pq.Error
+	var netErr net.Error
+	return errors.As(err, &pqErr) || errors.As(err, &netErr) ||
+		errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) || errors.Is(err, sql.ErrTxDone)
+}
+
+// isJSONRequest tells whether the body of the request is JSON
+func isJSONRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// readJSON decodes a JSON body with a single object into dst, rejecting unknown fields and bodies over
+// maxJSONBodySize, and turns the decoding errors into messages that make sense to the client
+func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxJSONBodySize)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err != nil {
+		var syntaxError *json.SyntaxError
+		var typeError *json.UnmarshalTypeError
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &syntaxError):
+			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return errors.New("body contains badly-formed JSON")
+		case errors.As(err, &typeError):
+			if typeError.Field != "" {
+				return fmt.Errorf("body contains an invalid value for the %q field", typeError.Field)
+			}
+			return fmt.Errorf("body contains an invalid value (at character %d)", typeError.Offset)
+		case errors.Is(err, io.EOF):
+			return errors.New("body must not be empty")
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			return fmt.Errorf("body contains unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+		case errors.As(err, &maxBytesError):
+			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+		default:
+			return err
+		}
+	}
+
+	// un solo objeto por peticion
+	err = dec.Decode(&struct{}{})
+	if !errors.Is(err, io.EOF) {
+		return errors.New("body must only contain a single JSON value")
+	}
+
+	return nil
 }
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestReadJSON(t *testing.T) {
+	type payload struct {
+		Title   string `json:"title"`
+		Storage int    `json:"storage"`
+	}
+
+	// un objeto valido justo por encima del limite
+	large := fmt.Sprintf(`{"title": %q}`, strings.Repeat("a", maxJSONBodySize))
+
+	tests := []struct {
+		name    string
+		body    string
+		want    payload
+		wantErr string
+	}{
+		{name: "object", body: `{"title": "Doom", "storage": 40}`, want: payload{Title: "Doom", Storage: 40}},
+		{name: "trailing whitespace", body: "{\"title\": \"Doom\"}\n\t ", want: payload{Title: "Doom"}},
+		{name: "unknown field", body: `{"title": "Doom", "rating": 5}`, wantErr: `body contains unknown field "rating"`},
+		{name: "second object", body: `{"title": "Doom"}{"title": "Quake"}`, wantErr: "body must only contain a single JSON value"},
+		{name: "trailing garbage", body: `{"title": "Doom"} x`, wantErr: "body must only contain a single JSON value"},
+		{name: "empty", body: ``, wantErr: "body must not be empty"},
+		{name: "badly-formed", body: `{"title": "Doom",}`, wantErr: "body contains badly-formed JSON (at character 18)"},
+		{name: "cut short", body: `{"title": "Doom"`, wantErr: "body contains badly-formed JSON"},
+		{name: "wrong type", body: `{"storage": "40"}`, wantErr: `body contains an invalid value for the "storage" field`},
+		{name: "not an object", body: `[1, 2]`, wantErr: "body contains an invalid value (at character 1)"},
+		{name: "too large", body: large, wantErr: fmt.Sprintf("body must not be larger than %d bytes", maxJSONBodySize)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{}
+			req := httptest.NewRequest(http.MethodPut, "/v2/games", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+
+			var got payload
+			err := app.readJSON(rec, req, &got)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("readJSON() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readJSON() error = %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("readJSON() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		status      []int
+		wantStatus  int
+		wantMessage string
+		wantLog     bool
+	}{
+		{name: "client error", err: fmt.Errorf("invalid id parameter"), wantStatus: http.StatusBadRequest, wantMessage: "invalid id parameter"},
+		{name: "given status", err: fmt.Errorf("version mismatch"), status: []int{http.StatusPreconditionFailed}, wantStatus: http.StatusPreconditionFailed, wantMessage: "version mismatch"},
+		{name: "not found", err: sql.ErrNoRows, wantStatus: http.StatusNotFound, wantMessage: "the requested resource could not be found"},
+		{name: "unique violation", err: &pq.Error{Code: "23505"}, wantStatus: http.StatusConflict, wantMessage: "the resource already exists"},
+		{
+			name:        "database error",
+			err:         &pq.Error{Code: "08006", Message: "connection to 10.0.0.5 lost"},
+			wantStatus:  http.StatusInternalServerError,
+			wantMessage: "the server could not process the request",
+			wantLog:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			logger := slog.New(slog.NewTextHandler(&logs, nil))
+			app := &application{logger: logger}
+
+			// el logger y la ruta que dejan los middleware de la peticion
+			r := httptest.NewRequest(http.MethodGet, "/v1/game/7", nil)
+			ctx := context.WithValue(r.Context(), loggerContextKey, logger.With("request_id", "req-1"))
+			ctx = context.WithValue(ctx, requestInfoContextKey, &requestInfo{route: "/v1/game/:id"})
+			r = r.WithContext(ctx)
+
+			rec := httptest.NewRecorder()
+			app.errorJSON(rec, r, tt.err, tt.status...)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if want := fmt.Sprintf(`"message":%q`, tt.wantMessage); !strings.Contains(rec.Body.String(), want) {
+				t.Errorf("body = %s, want %s", rec.Body, want)
+			}
+			if strings.Contains(rec.Body.String(), "10.0.0.5") {
+				t.Errorf("body %s reveals the database error", rec.Body)
+			}
+
+			line := logs.String()
+			if !tt.wantLog {
+				if line != "" {
+					t.Errorf("logged %q, want nothing", line)
+				}
+				return
+			}
+			for _, want := range []string{"request_id=req-1", "route=/v1/game/:id", "method=GET", "10.0.0.5"} {
+				if !strings.Contains(line, want) {
+					t.Errorf("log %q does not have %s", line, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// v1DeprecationDate is when /v2 was released and /v1 deprecated
+var v1DeprecationDate = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+
+// routesV2 registers the /v2 api: resources under plural nouns and the http verb telling the action.
+// Most handlers are shared with /v1 and answer in the style of the version of the request
+func (app *application) routesV2(router router) {
+	router.HandlerFunc(http.MethodGet, "/v2/genres", app.getAllGenres)
+	router.HandlerFunc(http.MethodGet, "/v2/modes", app.getAllModes)
+
+	router.HandlerFunc(http.MethodGet, "/v2/games", app.listGames)
+	router.HandlerFunc(http.MethodPost, "/v2/games", app.requirePermission(permWriteGames, app.insertGame))
+	router.HandlerFunc(http.MethodGet, "/v2/games/:id", app.getOneGame)
+	router.HandlerFunc(http.MethodPut, "/v2/games/:id", app.requirePermission(permWriteGames, app.updateGame))
+	router.HandlerFunc(http.MethodPatch, "/v2/games/:id", app.requirePermission(permWriteGames, app.patchGame))
+	router.HandlerFunc(http.MethodDelete, "/v2/games/:id", app.requirePermission(permDeleteGames, app.deleteGame))
+	router.HandlerFunc(http.MethodPost, "/v2/games/:id/restore", app.requirePermission(permDeleteGames, app.restoreGame))
+
+	router.HandlerFunc(http.MethodGet, "/v2/games/:id/image", app.getGameImage)
+	router.HandlerFunc(http.MethodPut, "/v2/games/:id/image", app.requirePermission(permWriteGames, app.uploadGameImage))
+	router.HandlerFunc(http.MethodDelete, "/v2/games/:id/image", app.requirePermission(permWriteGames, app.deleteGameImage))
+
+	router.HandlerFunc(http.MethodGet, "/v2/games/:id/media", app.getGameMedia)
+	router.HandlerFunc(http.MethodPost, "/v2/games/:id/media", app.requirePermission(permWriteGames, app.insertMedia))
+	router.HandlerFunc(http.MethodGet, "/v2/games/:id/media/:media", app.getMediaImage)
+	router.HandlerFunc(http.MethodPatch, "/v2/games/:id/media/:media", app.requirePermission(permWriteGames, app.updateMedia))
+	router.HandlerFunc(http.MethodDelete, "/v2/games/:id/media/:media", app.requirePermission(permWriteGames, app.deleteMedia))
+
+	router.HandlerFunc(http.MethodGet, "/v2/images", app.getAllImages)
+	router.HandlerFunc(http.MethodGet, "/v2/images/manifest", app.getImagesManifest)
+}
+
+// isV2 tells whether the request was made to the /v2 api
+func isV2(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/v2/")
+}
+
+// gamePath returns the path of a game in the api version of the request
+func gamePath(r *http.Request, id int) string {
+	if isV2(r) {
+		return fmt.Sprintf("/v2/games/%d", id)
+	}
+
+	return fmt.Sprintf("/v1/game/%d", id)
+}
+
+// writeOK answers a successful update or delete: 204 without body in /v2, and the "OK" object in /v1
+func (app *application) writeOK(w http.ResponseWriter, r *http.Request, ok interface{}) {
+	if isV2(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, ok, "OK")
+}
+
+// writeCreated answers the creation of the resource at location: 201 with its Location in /v2, and 200 in /v1
+func (app *application) writeCreated(w http.ResponseWriter, r *http.Request, location string, data interface{}, wrap string) {
+	if isV2(r) {
+		w.Header().Set("Location", location)
+		app.writeJSON(w, http.StatusCreated, data, wrap)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, data, wrap)
+}
+
+// listGames handles GET /v2/games
+// lists every game, or the ones of a genre with ?genre=
+func (app *application) listGames(w http.ResponseWriter, r *http.Request) {
+	genre := r.URL.Query().Get("genre")
+	if genre == "" {
+		app.getAllGames(w, r)
+		return
+	}
+
+	genreID, err := strconv.Atoi(genre)
+	if err != nil {
+		app.log(r).Warn("invalid genre parameter", "error", err)
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	games, err := app.db(r).GetAllGamesByGenre(r.Context(), genreID)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	for _, game := range games {
+		game.ImageUrl = ""
+		setMediaUrls(r, game)
+	}
+
+	app.writeJSON(w, http.StatusOK, games, "games")
+}
+
+// deprecateV1 marks the /v1 responses as deprecated in favour of /v2, announcing when /v1 will be removed
+func (app *application) deprecateV1(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v1/") {
+			w.Header().Set("Deprecation", "@"+strconv.FormatInt(v1DeprecationDate.Unix(), 10))
+			if !app.config.v1Sunset.IsZero() {
+				w.Header().Set("Sunset", app.config.v1Sunset.UTC().Format(http.TimeFormat))
+			}
+			w.Header().Add("Link", `</v2/>; rel="successor-version"`)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
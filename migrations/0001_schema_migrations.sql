@@ -0,0 +1,9 @@
+-- Keeps track of the migrations already applied to the database.
+-- Apply the files in this folder in order, e.g. psql gamesdb -f migrations/0001_schema_migrations.sql
+
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO schema_migrations (version) VALUES (1) ON CONFLICT DO NOTHING;
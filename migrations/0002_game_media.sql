@@ -0,0 +1,16 @@
+-- Screenshots and artwork of a game, besides its cover (games.image_url)
+
+CREATE TABLE IF NOT EXISTS game_media (
+	id SERIAL PRIMARY KEY,
+	game_id INTEGER NOT NULL REFERENCES games (id) ON DELETE CASCADE,
+	kind VARCHAR(20) NOT NULL DEFAULT 'screenshot',
+	file_name TEXT NOT NULL,
+	caption TEXT NOT NULL DEFAULT '',
+	position INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS game_media_game_id_position_idx ON game_media (game_id, position);
+
+INSERT INTO schema_migrations (version) VALUES (2) ON CONFLICT DO NOTHING;
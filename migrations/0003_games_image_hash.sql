@@ -0,0 +1,5 @@
+-- Perceptual hash (dHash) of the cover, used to detect the same art attached to two games
+
+ALTER TABLE games ADD COLUMN IF NOT EXISTS image_hash BIGINT;
+
+INSERT INTO schema_migrations (version) VALUES (3) ON CONFLICT DO NOTHING;
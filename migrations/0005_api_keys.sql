@@ -0,0 +1,18 @@
+-- Keys for machine clients, sent in the X-API-Key header. Only the sha256 of the key is stored;
+-- prefix is the public part of the key used to find it
+
+CREATE TABLE IF NOT EXISTS api_keys (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(100) NOT NULL,
+	prefix VARCHAR(32) NOT NULL UNIQUE,
+	key_hash VARCHAR(64) NOT NULL,
+	scope VARCHAR(20) NOT NULL DEFAULT 'read' CHECK (scope IN ('read', 'write', 'admin')),
+	expires_at TIMESTAMP,
+	revoked_at TIMESTAMP,
+	last_used_at TIMESTAMP,
+	created_by INTEGER REFERENCES users (id) ON DELETE SET NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO schema_migrations (version) VALUES (5) ON CONFLICT DO NOTHING;
@@ -0,0 +1,5 @@
+-- Version of each game, raised on every write so clients can detect concurrent edits (ETag / If-Match)
+
+ALTER TABLE games ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+
+INSERT INTO schema_migrations (version) VALUES (6) ON CONFLICT DO NOTHING;
@@ -0,0 +1,18 @@
+-- Idempotency-Key of the write requests of each client, with the sha256 of the request and the response
+-- to replay on retries. status is NULL while the first request is still running
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	client VARCHAR(100) NOT NULL,
+	key VARCHAR(255) NOT NULL,
+	request_hash VARCHAR(64) NOT NULL,
+	status INTEGER,
+	headers JSONB,
+	body BYTEA,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	expires_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (client, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
+
+INSERT INTO schema_migrations (version) VALUES (7) ON CONFLICT DO NOTHING;
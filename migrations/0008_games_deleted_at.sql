@@ -0,0 +1,7 @@
+-- Soft delete of games: deleted games stay in the table, hidden, until they are restored or purged
+
+ALTER TABLE games ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS games_deleted_at_idx ON games (deleted_at) WHERE deleted_at IS NOT NULL;
+
+INSERT INTO schema_migrations (version) VALUES (8) ON CONFLICT DO NOTHING;
@@ -0,0 +1,17 @@
+-- Every change of the catalog: who (actor, request_id) did what (action) to which row (entity, entity_id),
+-- with the fields that changed as {"field": {"before": ..., "after": ...}}
+
+CREATE TABLE IF NOT EXISTS audit_log (
+	id BIGSERIAL PRIMARY KEY,
+	entity VARCHAR(20) NOT NULL,
+	entity_id INTEGER NOT NULL,
+	action VARCHAR(20) NOT NULL,
+	actor VARCHAR(255) NOT NULL,
+	request_id VARCHAR(128) NOT NULL DEFAULT '',
+	changes JSONB NOT NULL DEFAULT '{}',
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_entity_idx ON audit_log (entity, entity_id, id);
+
+INSERT INTO schema_migrations (version) VALUES (9) ON CONFLICT DO NOTHING;
@@ -0,0 +1,6 @@
+-- SHA-256 and size of the cover file, stored at upload so the images manifest does not read every cover
+
+ALTER TABLE games ADD COLUMN IF NOT EXISTS image_sha256 TEXT;
+ALTER TABLE games ADD COLUMN IF NOT EXISTS image_size BIGINT;
+
+INSERT INTO schema_migrations (version) VALUES (10) ON CONFLICT DO NOTHING;
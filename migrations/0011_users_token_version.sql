@@ -0,0 +1,6 @@
+-- Version of the tokens of each user, raised when the password or the role changes so older tokens are
+-- rejected. It starts at 1, so the tokens signed before this migration, without it, must sign in again
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS token_version INTEGER NOT NULL DEFAULT 1;
+
+INSERT INTO schema_migrations (version) VALUES (11) ON CONFLICT DO NOTHING;
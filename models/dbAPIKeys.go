@@ -0,0 +1,176 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// GetAllAPIKeys returns all api keys, revoked ones included, and error, if any
+func (m *DBModels) GetAllAPIKeys(ctx context.Context) ([]*APIKey, error) {
+	defer m.observe("GetAllAPIKeys", time.Now())
+	ctx, cancel := m.readContext(ctx)
+	defer cancel()
+
+	query := `SELECT id, name, prefix, key_hash, scope, expires_at, revoked_at, last_used_at, created_by, created_at, updated_at
+				FROM api_keys
+				ORDER BY id
+			`
+
+	rows, err := m.query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// GetOneAPIKey returns one api key by id and error, if any
+func (m *DBModels) GetOneAPIKey(ctx context.Context, id int) (*APIKey, error) {
+	defer m.observe("GetOneAPIKey", time.Now())
+	ctx, cancel := m.readContext(ctx)
+	defer cancel()
+
+	query := `SELECT id, name, prefix, key_hash, scope, expires_at, revoked_at, last_used_at, created_by, created_at, updated_at
+				FROM api_keys
+				WHERE id = $1
+			`
+
+	return scanAPIKey(m.queryRow(ctx, query, id))
+}
+
+// GetAPIKeyByPrefix returns one api key by its public prefix and error, if any
+func (m *DBModels) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*APIKey, error) {
+	defer m.observe("GetAPIKeyByPrefix", time.Now())
+	ctx, cancel := m.readContext(ctx)
+	defer cancel()
+
+	query := `SELECT id, name, prefix, key_hash, scope, expires_at, revoked_at, last_used_at, created_by, created_at, updated_at
+				FROM api_keys
+				WHERE prefix = $1
+			`
+
+	return scanAPIKey(m.queryRow(ctx, query, prefix))
+}
+
+// InsertAPIKey adds an api key and sets its id
+func (m *DBModels) InsertAPIKey(ctx context.Context, key *APIKey) error {
+	defer m.observe("InsertAPIKey", time.Now())
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	query := `INSERT INTO api_keys (name, prefix, key_hash, scope, expires_at, created_by, created_at, updated_at)
+				VALUES ($1, $2, $3, $4, $5, $6, NOW(), NOW())
+				RETURNING id, created_at
+			`
+
+	row := m.queryRow(ctx, query, key.Name, key.Prefix, key.KeyHash, key.Scope, key.ExpiresAt, key.CreatedBy)
+	err := row.Scan(
+		&key.ID,
+		&key.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateAPIKey changes the name, scope and expiry of an api key
+func (m *DBModels) UpdateAPIKey(ctx context.Context, key *APIKey) error {
+	defer m.observe("UpdateAPIKey", time.Now())
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	query := `UPDATE api_keys SET name = $1, scope = $2, expires_at = $3, updated_at = NOW()
+			WHERE id = $4;
+			`
+
+	result, err := m.exec(ctx, query, key.Name, key.Scope, key.ExpiresAt, key.ID)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(result)
+}
+
+// RevokeAPIKey revokes an api key. It stays listed but is no longer accepted
+func (m *DBModels) RevokeAPIKey(ctx context.Context, id int) error {
+	defer m.observe("RevokeAPIKey", time.Now())
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	query := `UPDATE api_keys SET revoked_at = NOW(), updated_at = NOW()
+			WHERE id = $1 AND revoked_at IS NULL;
+			`
+
+	result, err := m.exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(result)
+}
+
+// TouchAPIKey records that an api key has just been used
+func (m *DBModels) TouchAPIKey(ctx context.Context, id int) error {
+	defer m.observe("TouchAPIKey", time.Now())
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	query := `UPDATE api_keys SET last_used_at = NOW()
+			WHERE id = $1;
+			`
+
+	_, err := m.exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// scanner is satisfied by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Reusable private function
+func scanAPIKey(row scanner) (*APIKey, error) {
+	var key APIKey
+	var createdBy sql.NullInt64
+
+	err := row.Scan(
+		&key.ID,
+		&key.Name,
+		&key.Prefix,
+		&key.KeyHash,
+		&key.Scope,
+		&key.ExpiresAt,
+		&key.RevokedAt,
+		&key.LastUsedAt,
+		&createdBy,
+		&key.CreatedAt,
+		&key.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if createdBy.Valid {
+		id := int(createdBy.Int64)
+		key.CreatedBy = &id
+	}
+
+	return &key, nil
+}
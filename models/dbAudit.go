@@ -0,0 +1,232 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Entities and actions recorded in the audit log
+const (
+	AuditGame  = "game"
+	AuditMedia = "media"
+	AuditGenre = "genre"
+	AuditMode  = "mode"
+
+	AuditInsert  = "insert"
+	AuditUpdate  = "update"
+	AuditLike    = "like"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditPurge   = "purge"
+)
+
+// systemActor is the actor of the changes made outside a request, like the purge of the trash
+const systemActor = "system"
+
+type auditContextKey struct{}
+
+// auditActor is who makes the changes of a context
+type auditActor struct {
+	actor     string
+	requestID string
+}
+
+// WithActor returns a context whose writes are recorded in the audit log as made by actor, in the request requestID
+func WithActor(ctx context.Context, actor, requestID string) context.Context {
+	return context.WithValue(ctx, auditContextKey{}, auditActor{actor: actor, requestID: requestID})
+}
+
+// actorFromContext returns the actor and request id of the writes of ctx
+func actorFromContext(ctx context.Context) (string, string) {
+	a, ok := ctx.Value(auditContextKey{}).(auditActor)
+	if !ok || a.actor == "" {
+		return systemActor, a.requestID
+	}
+
+	return a.actor, a.requestID
+}
+
+// AuditFilter selects the entries of the audit log; empty fields match everything
+type AuditFilter struct {
+	Entity   string
+	EntityID int
+	Actor    string
+	Page     int
+	PageSize int
+}
+
+// GetAuditLog returns a page of the audit log, the most recent entries first, the total number of
+// entries that match the filter, and error, if any
+func (m *DBModels) GetAuditLog(ctx context.Context, filter AuditFilter) ([]*AuditEntry, int, error) {
+	defer m.observe("GetAuditLog", time.Now())
+	ctx, cancel := m.readContext(ctx)
+	defer cancel()
+
+	var where []string
+	var args []interface{}
+	if filter.Entity != "" {
+		args = append(args, filter.Entity)
+		where = append(where, fmt.Sprintf("entity = $%d", len(args)))
+	}
+	if filter.EntityID != 0 {
+		args = append(args, filter.EntityID)
+		where = append(where, fmt.Sprintf("entity_id = $%d", len(args)))
+	}
+	if filter.Actor != "" {
+		args = append(args, filter.Actor)
+		where = append(where, fmt.Sprintf("actor = $%d", len(args)))
+	}
+
+	conditions := ""
+	if len(where) > 0 {
+		conditions = "WHERE " + strings.Join(where, " AND ")
+	}
+
+	args = append(args, filter.PageSize, (filter.Page-1)*filter.PageSize)
+	query := fmt.Sprintf(`SELECT COUNT(*) OVER(), id, entity, entity_id, action, actor, request_id, changes, created_at
+				FROM audit_log
+				%s
+				ORDER BY id DESC
+				LIMIT $%d OFFSET $%d
+			`, conditions, len(args)-1, len(args))
+
+	rows, err := m.query(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	total := 0
+	entries := []*AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		var changes []byte
+		err := rows.Scan(
+			&total,
+			&entry.ID,
+			&entry.Entity,
+			&entry.EntityID,
+			&entry.Action,
+			&entry.Actor,
+			&entry.RequestID,
+			&changes,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		err = json.Unmarshal(changes, &entry.Changes)
+		if err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, &entry)
+	}
+
+	return entries, total, rows.Err()
+}
+
+// audit records a change of the catalog made by the actor of ctx. before and after are the fields of the
+// entity (nil when it did not exist) and only the ones that differ are stored. Updates that change nothing
+// are not recorded. It must run in the transaction of the change, so that both are written or neither is
+func (m *DBModels) audit(ctx context.Context, entity string, id int, action string, before, after map[string]interface{}) error {
+	action, changes := auditChanges(action, before, after)
+	if action == AuditUpdate && len(changes) == 0 {
+		return nil
+	}
+
+	encoded, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	actor, requestID := actorFromContext(ctx)
+
+	query := `INSERT INTO audit_log (entity, entity_id, action, actor, request_id, changes, created_at)
+				VALUES ($1, $2, $3, $4, $5, $6, NOW())
+			`
+
+	_, err = m.exec(ctx, query, entity, id, action, actor, requestID, encoded)
+	if err != nil {
+		return fmt.Errorf("writing audit log: %w", err)
+	}
+
+	return nil
+}
+
+// auditChanges returns the fields that differ between before and after, and the action they are recorded
+// as: an update that only changes the likes is a like
+func auditChanges(action string, before, after map[string]interface{}) (string, map[string]AuditChange) {
+	changes := make(map[string]AuditChange)
+	for field, value := range after {
+		if old, ok := before[field]; !ok || !reflect.DeepEqual(old, value) {
+			changes[field] = AuditChange{Before: before[field], After: value}
+		}
+	}
+	for field, old := range before {
+		if _, ok := after[field]; !ok {
+			changes[field] = AuditChange{Before: old}
+		}
+	}
+	if _, ok := changes["likes"]; action == AuditUpdate && ok && len(changes) == 1 {
+		action = AuditLike
+	}
+
+	return action, changes
+}
+
+// GameAuditChanges returns the action and the changes recorded in the audit log when a game is updated
+// from before to after
+func GameAuditChanges(before, after *Game) (string, map[string]AuditChange) {
+	return auditChanges(AuditUpdate, gameAuditFields(before), gameAuditFields(after))
+}
+
+// gameAuditFields returns the fields of a game recorded in the audit log
+func gameAuditFields(game *Game) map[string]interface{} {
+	genres := []int{}
+	for id := range game.Genres {
+		genres = append(genres, id)
+	}
+	sort.Ints(genres)
+
+	modes := []int{}
+	for id := range game.Modes {
+		modes = append(modes, id)
+	}
+	sort.Ints(modes)
+
+	developers := append([]string{}, game.Developers...)
+	publishers := append([]string{}, game.Publishers...)
+
+	return map[string]interface{}{
+		"title":        game.Title,
+		"image_url":    game.ImageUrl,
+		"genres":       genres,
+		"modes":        modes,
+		"developers":   developers,
+		"publishers":   publishers,
+		"release_date": game.ReleaseDate.UTC().Format("2006-01-02"),
+		"storage":      game.Storage,
+		"likes":        game.Likes,
+	}
+}
+
+// gameSnapshot reads the current fields of a game, in the trash or not, to record them in the audit log
+func (m *DBModels) gameSnapshot(ctx context.Context, id int) (map[string]interface{}, error) {
+	query := `SELECT id, title, image_url, developers, publishers, release_date, storage, likes, version, created_at, updated_at
+				FROM games
+				WHERE id = $1
+			`
+
+	game, err := m.getGameFromRow(ctx, m.queryRow(ctx, query, id), id)
+	if err != nil {
+		return nil, err
+	}
+
+	return gameAuditFields(game), nil
+}
@@ -0,0 +1,129 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// ReserveIdempotencyKey stores the key of a request that is starting, valid for ttl. If the client
+// already used the key and it has not expired, nothing is stored and the existing key is returned.
+// A key without a response is only held for lease: after that the request is taken as lost and the key
+// is reserved again, so a crash or a failure storing the response does not block the retries for the ttl
+func (m *DBModels) ReserveIdempotencyKey(ctx context.Context, client, key, requestHash string, ttl, lease time.Duration) (*IdempotencyKey, error) {
+	defer m.observe("ReserveIdempotencyKey", time.Now())
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	// una clave caducada, o reservada por una peticion que no termino, se reutiliza como si fuera nueva
+	query := `INSERT INTO idempotency_keys (client, key, request_hash, created_at, expires_at)
+				VALUES ($1, $2, $3, NOW(), NOW() + $4::float8 * INTERVAL '1 second')
+				ON CONFLICT (client, key) DO UPDATE
+					SET request_hash = EXCLUDED.request_hash, status = NULL, headers = NULL, body = NULL,
+						created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at
+					WHERE idempotency_keys.expires_at < NOW()
+						OR (idempotency_keys.status IS NULL AND idempotency_keys.created_at < NOW() - $5::float8 * INTERVAL '1 second')
+				RETURNING client
+			`
+
+	var inserted string
+	err := m.queryRow(ctx, query, client, key, requestHash, ttl.Seconds(), lease.Seconds()).Scan(&inserted)
+	if err == nil {
+		return nil, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	query = `SELECT client, key, request_hash, status, headers, body, created_at, expires_at
+				FROM idempotency_keys
+				WHERE client = $1 AND key = $2
+			`
+
+	var existing IdempotencyKey
+	var status sql.NullInt64
+	var headers []byte
+	err = m.queryRow(ctx, query, client, key).Scan(
+		&existing.Client,
+		&existing.Key,
+		&existing.RequestHash,
+		&status,
+		&headers,
+		&existing.Body,
+		&existing.CreatedAt,
+		&existing.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	existing.Status = int(status.Int64)
+
+	if headers != nil {
+		err = json.Unmarshal(headers, &existing.Headers)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &existing, nil
+}
+
+// SaveIdempotentResponse stores the response of the request of a reserved key
+func (m *DBModels) SaveIdempotentResponse(ctx context.Context, client, key string, status int, headers map[string][]string, body []byte) error {
+	defer m.observe("SaveIdempotentResponse", time.Now())
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	encoded, err := json.Marshal(headers)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE idempotency_keys SET status = $1, headers = $2, body = $3
+			WHERE client = $4 AND key = $5;
+			`
+
+	_, err = m.exec(ctx, query, status, encoded, body, client, key)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ReleaseIdempotencyKey deletes a reserved key whose request failed, so it can be retried
+func (m *DBModels) ReleaseIdempotencyKey(ctx context.Context, client, key string) error {
+	defer m.observe("ReleaseIdempotencyKey", time.Now())
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	query := `DELETE FROM idempotency_keys
+			WHERE client = $1 AND key = $2;
+			`
+
+	_, err := m.exec(ctx, query, client, key)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteExpiredIdempotencyKeys deletes the keys past their window and returns how many were deleted
+func (m *DBModels) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	defer m.observe("DeleteExpiredIdempotencyKeys", time.Now())
+	ctx, cancel := m.bulkContext(ctx)
+	defer cancel()
+
+	query := `DELETE FROM idempotency_keys
+			WHERE expires_at < NOW();
+			`
+
+	result, err := m.exec(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
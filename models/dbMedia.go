@@ -0,0 +1,347 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// GetGameMedia returns the screenshots and artwork of a game, in gallery order, and error, if any.
+// It returns sql.ErrNoRows if the game does not exist or is in the trash
+func (m *DBModels) GetGameMedia(ctx context.Context, gameID int) ([]*Media, error) {
+	defer m.observe("GetGameMedia", time.Now())
+	ctx, cancel := m.readContext(ctx)
+	defer cancel()
+
+	// la galeria de un juego en la papelera no se muestra
+	var exists bool
+	err := m.queryRow(ctx, `SELECT EXISTS (SELECT 1 FROM games WHERE id = $1 AND deleted_at IS NULL)`, gameID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+
+	return m.getGameMedia(ctx, gameID)
+}
+
+// GetOneMedia returns one media item of a game and error, if any. The media of the games in the trash is not found
+func (m *DBModels) GetOneMedia(ctx context.Context, gameID, mediaID int) (*Media, error) {
+	defer m.observe("GetOneMedia", time.Now())
+	ctx, cancel := m.readContext(ctx)
+	defer cancel()
+
+	query := `SELECT gm.id, gm.game_id, gm.kind, gm.file_name, gm.caption, gm.position, gm.created_at, gm.updated_at
+				FROM game_media gm
+				JOIN games g ON (g.id = gm.game_id)
+				WHERE gm.id = $1 AND gm.game_id = $2 AND g.deleted_at IS NULL
+			`
+
+	row := m.queryRow(ctx, query, mediaID, gameID)
+
+	var media Media
+	err := row.Scan(
+		&media.ID,
+		&media.GameID,
+		&media.Kind,
+		&media.FileName,
+		&media.Caption,
+		&media.Position,
+		&media.CreatedAt,
+		&media.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &media, nil
+}
+
+// InsertMedia adds a media item at the end of the gallery of its game. Like the other writes of the media,
+// if version is not zero it is only added if the game still has that version, returning ErrEditConflict otherwise
+func (m *DBModels) InsertMedia(ctx context.Context, media *Media, version int) error {
+	defer m.observe("InsertMedia", time.Now())
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	query := `INSERT INTO game_media (game_id, kind, file_name, caption, position, created_at, updated_at)
+				VALUES ($1, $2, $3, $4,
+					(SELECT COALESCE(MAX(position) + 1, 0) FROM game_media WHERE game_id = $1),
+					NOW(), NOW())
+				RETURNING id, position
+			`
+
+	return m.withTx(ctx, func(tx *DBModels) error {
+		row := tx.queryRow(ctx, query, media.GameID, media.Kind, media.FileName, media.Caption)
+		err := row.Scan(
+			&media.ID,
+			&media.Position,
+		)
+		if err != nil {
+			return err
+		}
+
+		err = tx.audit(ctx, AuditMedia, media.ID, AuditInsert, nil, map[string]interface{}{
+			"game_id":   media.GameID,
+			"kind":      media.Kind,
+			"file_name": media.FileName,
+			"caption":   media.Caption,
+			"position":  media.Position,
+		})
+		if err != nil {
+			return err
+		}
+
+		return tx.touchGame(ctx, media.GameID, version)
+	})
+}
+
+// UpdateMedia changes the caption of a media item and/or moves it to position in the gallery of its game,
+// shifting the others, in one transaction that raises the version of the game once. A nil caption or
+// position is left as it is. The media of the games in the trash is not found
+func (m *DBModels) UpdateMedia(ctx context.Context, gameID, mediaID int, caption *string, position *int, version int) error {
+	defer m.observe("UpdateMedia", time.Now())
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	if caption == nil && position == nil {
+		return nil
+	}
+
+	return m.withTx(ctx, func(tx *DBModels) error {
+		before := make(map[string]interface{})
+		after := make(map[string]interface{})
+
+		if caption != nil {
+			previous, err := tx.setMediaCaption(ctx, gameID, mediaID, *caption)
+			if err != nil {
+				return err
+			}
+			before["caption"] = previous
+			after["caption"] = *caption
+		}
+
+		if position != nil {
+			previous, moved, err := tx.moveMedia(ctx, gameID, mediaID, *position)
+			if err != nil {
+				return err
+			}
+			before["position"] = previous
+			after["position"] = moved
+		}
+
+		err := tx.audit(ctx, AuditMedia, mediaID, AuditUpdate, before, after)
+		if err != nil {
+			return err
+		}
+
+		// la version se comprueba al final: si no coincide se deshace todo el cambio
+		return tx.touchGame(ctx, gameID, version)
+	})
+}
+
+// setMediaCaption changes the caption of a media item and returns the previous one
+func (m *DBModels) setMediaCaption(ctx context.Context, gameID, mediaID int, caption string) (string, error) {
+	// old es la fila antes del UPDATE, para el registro de auditoria
+	query := `UPDATE game_media gm SET caption = $1, updated_at = NOW()
+			FROM game_media old, games g
+			WHERE gm.id = $2 AND gm.game_id = $3 AND old.id = gm.id AND g.id = gm.game_id AND g.deleted_at IS NULL
+			RETURNING old.caption;
+			`
+
+	var previous string
+	err := m.queryRow(ctx, query, caption, mediaID, gameID).Scan(&previous)
+	if err != nil {
+		return "", err
+	}
+
+	return previous, nil
+}
+
+// moveMedia moves a media item to position in the gallery of its game, shifting the others, and returns
+// its previous position and the one it ends at
+func (m *DBModels) moveMedia(ctx context.Context, gameID, mediaID, position int) (int, int, error) {
+	query := `SELECT gm.id FROM game_media gm
+				JOIN games g ON (g.id = gm.game_id)
+				WHERE gm.game_id = $1 AND g.deleted_at IS NULL
+				ORDER BY gm.position, gm.id
+				FOR UPDATE OF gm
+			`
+
+	rows, err := m.query(ctx, query, gameID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var ids []int
+	found := false
+	previous := 0
+	for rows.Next() {
+		var id int
+		err := rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		if id == mediaID {
+			found = true
+			previous = len(ids)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	if !found {
+		return 0, 0, sql.ErrNoRows
+	}
+
+	if position < 0 {
+		position = 0
+	}
+	if position > len(ids) {
+		position = len(ids)
+	}
+	ids = append(ids[:position], append([]int{mediaID}, ids[position:]...)...)
+
+	query = `UPDATE game_media SET position = $1, updated_at = NOW()
+			WHERE id = $2;
+			`
+
+	for i, id := range ids {
+		_, err = m.exec(ctx, query, i, id)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return previous, position, nil
+}
+
+// DeleteMedia deletes a media item from the gallery of its game
+func (m *DBModels) DeleteMedia(ctx context.Context, gameID, mediaID int, version int) error {
+	defer m.observe("DeleteMedia", time.Now())
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	query := `DELETE FROM game_media
+			WHERE id = $1 AND game_id = $2
+			RETURNING file_name, caption;
+			`
+
+	return m.withTx(ctx, func(tx *DBModels) error {
+		var fileName, caption string
+		err := tx.queryRow(ctx, query, mediaID, gameID).Scan(&fileName, &caption)
+		if err != nil {
+			return err
+		}
+
+		err = tx.audit(ctx, AuditMedia, mediaID, AuditDelete, map[string]interface{}{
+			"game_id":   gameID,
+			"file_name": fileName,
+			"caption":   caption,
+		}, nil)
+		if err != nil {
+			return err
+		}
+
+		return tx.touchGame(ctx, gameID, version)
+	})
+}
+
+// GetAllMediaFiles returns the file of every media item, by media id, and error, if any
+func (m *DBModels) GetAllMediaFiles(ctx context.Context) (map[int]string, error) {
+	defer m.observe("GetAllMediaFiles", time.Now())
+	ctx, cancel := m.bulkContext(ctx)
+	defer cancel()
+
+	query := `SELECT id, file_name
+				FROM game_media
+			`
+
+	rows, err := m.query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var fileName string
+		err := rows.Scan(
+			&id,
+			&fileName,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		files[id] = fileName
+	}
+
+	return files, rows.Err()
+}
+
+// Reusable private function
+func (m *DBModels) getGameMedia(ctx context.Context, gameID int) ([]*Media, error) {
+	query := `SELECT id, game_id, kind, file_name, caption, position, created_at, updated_at
+				FROM game_media
+				WHERE game_id = $1
+				ORDER BY position, id
+			`
+
+	rows, err := m.query(ctx, query, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	media := []*Media{}
+	for rows.Next() {
+		item, err := scanMedia(rows)
+		if err != nil {
+			return nil, err
+		}
+		media = append(media, item)
+	}
+
+	return media, rows.Err()
+}
+
+// scanMedia reads a media item from the columns id, game_id, kind, file_name, caption, position,
+// created_at and updated_at of rows
+func scanMedia(rows *sql.Rows) (*Media, error) {
+	var item Media
+	err := rows.Scan(
+		&item.ID,
+		&item.GameID,
+		&item.Kind,
+		&item.FileName,
+		&item.Caption,
+		&item.Position,
+		&item.CreatedAt,
+		&item.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+// checkAffected returns sql.ErrNoRows if the statement did not touch any row
+func checkAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
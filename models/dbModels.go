@@ -3,26 +3,127 @@ package models
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"log/slog"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// Default timeouts of the database operations, used when Timeouts leaves them at zero
+const (
+	DefaultReadTimeout  = 3 * time.Second
+	DefaultWriteTimeout = 5 * time.Second
+	DefaultBulkTimeout  = 30 * time.Second
+)
+
+// Timeouts bound how long each kind of operation may take: reads of a few rows, writes, and bulk
+// reads of a whole table like the ones of the images garbage collector
+type Timeouts struct {
+	Read  time.Duration
+	Write time.Duration
+	Bulk  time.Duration
+}
+
+// ErrEditConflict is returned by the writes conditioned on the version of a game when it was
+// changed by someone else since it was read
+var ErrEditConflict = errors.New("edit conflict: the game was modified")
+
 type DBModels struct {
-	DB *sql.DB
+	DB       *sql.DB
+	Logger   *slog.Logger
+	Timeouts Timeouts
+	// SlowQuery, if not zero, logs the statements that take longer
+	SlowQuery time.Duration
+	// ObserveQuery, if set, is called with the name and duration of every model method
+	ObserveQuery func(query string, duration time.Duration)
+	// Tracer starts the spans of the SQL statements, the global tracer is used if nil
+	Tracer trace.Tracer
+
+	// tx is the transaction the statements run in, set by withTx
+	tx *sql.Tx
+}
+
+// WithLogger returns a copy of the models that logs with logger, e.g. the logger of a request
+func (m DBModels) WithLogger(logger *slog.Logger) *DBModels {
+	m.Logger = logger
+	return &m
+}
+
+// withTx runs fn with a copy of the models whose statements run in a transaction, which is committed
+// if fn returns no error and rolled back otherwise. If m is already in a transaction, fn runs in it
+func (m *DBModels) withTx(ctx context.Context, fn func(tx *DBModels) error) error {
+	if m.tx != nil {
+		return fn(m)
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	inTx := *m
+	inTx.tx = tx
+	err = fn(&inTx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// logger returns the logger of the models, or the default one if none was set
+func (m *DBModels) logger() *slog.Logger {
+	if m.Logger != nil {
+		return m.Logger
+	}
+
+	return slog.Default()
+}
+
+// timeoutContext returns ctx with a timeout of d, or of fallback if d is zero
+func timeoutContext(ctx context.Context, d, fallback time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		d = fallback
+	}
+
+	return context.WithTimeout(ctx, d)
+}
+
+func (m *DBModels) readContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return timeoutContext(ctx, m.Timeouts.Read, DefaultReadTimeout)
+}
+
+func (m *DBModels) writeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return timeoutContext(ctx, m.Timeouts.Write, DefaultWriteTimeout)
+}
+
+func (m *DBModels) bulkContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return timeoutContext(ctx, m.Timeouts.Bulk, DefaultBulkTimeout)
+}
+
+// observe reports the time since start of the method named query, used as defer m.observe("Name", time.Now())
+func (m *DBModels) observe(query string, start time.Time) {
+	if m.ObserveQuery != nil {
+		m.ObserveQuery(query, time.Since(start))
+	}
 }
 
 // GetAllGenres returns all genres and error, if any
-func (m *DBModels) GetAllGenres() (map[int]string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *DBModels) GetAllGenres(ctx context.Context) (map[int]string, error) {
+	defer m.observe("GetAllGenres", time.Now())
+	ctx, cancel := m.readContext(ctx)
 	defer cancel()
 
 	query := `SELECT id, genre_name, created_at, updated_at 
 				FROM genres
 			`
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.query(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -47,15 +148,16 @@ func (m *DBModels) GetAllGenres() (map[int]string, error) {
 }
 
 // GetAllModes returns all modes and error, if any
-func (m *DBModels) GetAllModes() (map[int]string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *DBModels) GetAllModes(ctx context.Context) (map[int]string, error) {
+	defer m.observe("GetAllModes", time.Now())
+	ctx, cancel := m.readContext(ctx)
 	defer cancel()
 
 	query := `SELECT id, mode_name, created_at, updated_at 
 				FROM modes
 			`
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.query(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -80,16 +182,18 @@ func (m *DBModels) GetAllModes() (map[int]string, error) {
 }
 
 // GetAllGames returns all games and error, if any
-func (m *DBModels) GetAllGames() ([]*Game, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *DBModels) GetAllGames(ctx context.Context) ([]*Game, error) {
+	defer m.observe("GetAllGames", time.Now())
+	ctx, cancel := m.readContext(ctx)
 	defer cancel()
 
-	query := `SELECT id, title, image_url, developers, publishers, release_date, storage, likes, created_at, updated_at 
+	query := `SELECT id, title, image_url, developers, publishers, release_date, storage, likes, version, created_at, updated_at 
 				FROM games
+				WHERE deleted_at IS NULL
 				ORDER BY title
 			`
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.query(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -104,16 +208,17 @@ func (m *DBModels) GetAllGames() ([]*Game, error) {
 }
 
 // GetOneGame returns one game and error, if any
-func (m *DBModels) GetOneGame(id int) (*Game, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *DBModels) GetOneGame(ctx context.Context, id int) (*Game, error) {
+	defer m.observe("GetOneGame", time.Now())
+	ctx, cancel := m.readContext(ctx)
 	defer cancel()
 
-	query := `SELECT id, title, image_url, developers, publishers, release_date, storage, likes, created_at, updated_at 
+	query := `SELECT id, title, image_url, developers, publishers, release_date, storage, likes, version, created_at, updated_at 
 				FROM games 
-				WHERE id = $1
+				WHERE id = $1 AND deleted_at IS NULL
 			`
 
-	row := m.DB.QueryRowContext(ctx, query, id)
+	row := m.queryRow(ctx, query, id)
 
 	game, err := m.getGameFromRow(ctx, row, id)
 	if err != nil {
@@ -124,16 +229,17 @@ func (m *DBModels) GetOneGame(id int) (*Game, error) {
 }
 
 // GetGameImage returns image from game id and error, if any
-func (m *DBModels) GetGameImage(id int) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *DBModels) GetGameImage(ctx context.Context, id int) (string, error) {
+	defer m.observe("GetGameImage", time.Now())
+	ctx, cancel := m.readContext(ctx)
 	defer cancel()
 
 	query := `SELECT image_url
 				FROM games 
-				WHERE id = $1
+				WHERE id = $1 AND deleted_at IS NULL
 			`
 
-	row := m.DB.QueryRowContext(ctx, query, id)
+	row := m.queryRow(ctx, query, id)
 
 	var image string
 	err := row.Scan(
@@ -146,16 +252,52 @@ func (m *DBModels) GetGameImage(id int) (string, error) {
 	return image, nil
 }
 
-// GetAllImages returns all images and error, if any
-func (m *DBModels) GetAllImages() (map[int]string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// GetAllImages returns the images of the games that are not in the trash and error, if any
+func (m *DBModels) GetAllImages(ctx context.Context) (map[int]string, error) {
+	defer m.observe("GetAllImages", time.Now())
+	ctx, cancel := m.bulkContext(ctx)
 	defer cancel()
 
-	query := `SELECT id, image_url
+	return m.getImages(ctx, `SELECT id, image_url
 				FROM games
+				WHERE deleted_at IS NULL
+			`)
+}
+
+// GetReferencedImages returns the images of every game, including the ones in the trash, which still
+// use them until they are purged, and error, if any
+func (m *DBModels) GetReferencedImages(ctx context.Context) (map[int]string, error) {
+	defer m.observe("GetReferencedImages", time.Now())
+	ctx, cancel := m.bulkContext(ctx)
+	defer cancel()
+
+	return m.getImages(ctx, `SELECT id, image_url
+				FROM games
+			`)
+}
+
+// IsImageReferenced tells whether a game, in the trash or not, or a media item uses the image file name
+func (m *DBModels) IsImageReferenced(ctx context.Context, name string) (bool, error) {
+	defer m.observe("IsImageReferenced", time.Now())
+	ctx, cancel := m.readContext(ctx)
+	defer cancel()
+
+	query := `SELECT EXISTS (SELECT 1 FROM games WHERE image_url = $1)
+				OR EXISTS (SELECT 1 FROM game_media WHERE file_name = $1)
 			`
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	var referenced bool
+	err := m.queryRow(ctx, query, name).Scan(&referenced)
+	if err != nil {
+		return false, err
+	}
+
+	return referenced, nil
+}
+
+// getImages reads the id and image of the games returned by query
+func (m *DBModels) getImages(ctx context.Context, query string) (map[int]string, error) {
+	rows, err := m.query(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -181,19 +323,19 @@ func (m *DBModels) GetAllImages() (map[int]string, error) {
 }
 
 // GetAllGamesByGenre returns all games of a certain genre and error, if any
-func (m *DBModels) GetAllGamesByGenre(genreID int) ([]*Game, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *DBModels) GetAllGamesByGenre(ctx context.Context, genreID int) ([]*Game, error) {
+	defer m.observe("GetAllGamesByGenre", time.Now())
+	ctx, cancel := m.readContext(ctx)
 	defer cancel()
 
-	gameIDsQuery := fmt.Sprintf("SELECT gg.game_id FROM games_genres gg"+
-		" LEFT JOIN genres g ON (g.id = gg.genre_id)"+
-		" WHERE g.genre_id = '%d'", genreID)
-
-	query := fmt.Sprintf("SELECT id, title, image_url, developers, publishers, release_date, storage, likes, created_at, updated_at"+
-		" FROM games"+
-		" WHERE id IN (%s)", gameIDsQuery)
+	query := `SELECT id, title, image_url, developers, publishers, release_date, storage, likes, version, created_at, updated_at
+				FROM games
+				WHERE id IN (SELECT gg.game_id FROM games_genres gg
+							WHERE gg.genre_id = $1)
+					AND deleted_at IS NULL
+			`
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.query(ctx, query, genreID)
 	if err != nil {
 		return nil, err
 	}
@@ -207,135 +349,697 @@ func (m *DBModels) GetAllGamesByGenre(genreID int) ([]*Game, error) {
 	return games, nil
 }
 
-func (m *DBModels) InsertGame(game *Game, genres []int, modes []int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *DBModels) InsertGame(ctx context.Context, game *Game, genres []int, modes []int) error {
+	defer m.observe("InsertGame", time.Now())
+	ctx, cancel := m.writeContext(ctx)
 	defer cancel()
 
-	// Insert game
-	query := fmt.Sprintf(`INSERT INTO games (title, image_url, developers, publishers, release_date,`+
-		` storage, likes, created_at, updated_at)`+
-		` VALUES ('%s', '%s', $1, $2, $3, %d, %d, NOW(), NOW())`, game.Title, game.ImageUrl, game.Storage, game.Likes)
+	err := m.withTx(ctx, func(tx *DBModels) error {
+		// Insert game
+		query := `INSERT INTO games (title, image_url, developers, publishers, release_date, storage, likes, created_at, updated_at)
+					VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), NOW())
+					RETURNING id, version
+				`
 
-	_, err := m.DB.ExecContext(ctx, query, pq.Array(game.Publishers), pq.Array(game.Developers), game.ReleaseDate.UTC().Format("2006-01-02"))
+		// el id se toma del propio INSERT: buscarlo por titulo falla si hay dos juegos con el mismo
+		row := tx.queryRow(ctx, query, game.Title, game.ImageUrl, pq.Array(game.Developers), pq.Array(game.Publishers),
+			game.ReleaseDate.UTC().Format("2006-01-02"), game.Storage, game.Likes)
+		err := row.Scan(
+			&game.ID,
+			&game.Version,
+		)
+		if err != nil {
+			return err
+		}
+
+		// Insert game_genres
+		query = `INSERT INTO games_genres (game_id, genre_id, created_at, updated_at)
+					VALUES ($1, $2, NOW(), NOW())
+				`
+		for _, genreID := range genres {
+			_, err = tx.exec(ctx, query, game.ID, genreID)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Insert game_modes
+		query = `INSERT INTO games_modes (game_id, mode_id, created_at, updated_at)
+					VALUES ($1, $2, NOW(), NOW())
+				`
+		for _, modeID := range modes {
+			_, err = tx.exec(ctx, query, game.ID, modeID)
+			if err != nil {
+				return err
+			}
+		}
+
+		after, err := tx.gameSnapshot(ctx, game.ID)
+		if err != nil {
+			return err
+		}
+
+		return tx.audit(ctx, AuditGame, game.ID, AuditInsert, nil, after)
+	})
 	if err != nil {
 		return err
 	}
-	fmt.Println("INSERTED")
-	// Get ID of the new game inserted
-	var gameID int
-	query = fmt.Sprintf(`SELECT id FROM games`+
-		` WHERE title = '%s'`, game.Title)
 
-	row := m.DB.QueryRowContext(ctx, query)
-	err = row.Scan(
-		&gameID,
-	)
+	m.logger().Info("game inserted", "game_id", game.ID, "title", game.Title)
+
+	return nil
+}
+
+// UpdateGame replaces every field of a game and its genres and modes. If game.Version is not zero the
+// game is only updated if it still has that version, returning ErrEditConflict otherwise. On success
+// game.Version is set to the new version
+func (m *DBModels) UpdateGame(ctx context.Context, id int, game *Game, genres []int, modes []int) error {
+	defer m.observe("UpdateGame", time.Now())
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	err := m.withTx(ctx, func(tx *DBModels) error {
+		before, err := tx.gameSnapshot(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		// Insert game
+		query := `UPDATE games SET title = $1, image_url = $2, developers = $3, publishers = $4, release_date = $5,
+						storage = $6, likes = $7, version = version + 1, updated_at = NOW()
+					WHERE id = $8 AND deleted_at IS NULL AND ($9 = 0 OR version = $9)
+					RETURNING version
+				`
+
+		row := tx.queryRow(ctx, query, game.Title, game.ImageUrl, pq.Array(game.Developers), pq.Array(game.Publishers),
+			game.ReleaseDate.UTC().Format("2006-01-02"), game.Storage, game.Likes, id, game.Version)
+		err = row.Scan(&game.Version)
+		if err == sql.ErrNoRows {
+			return tx.versionError(ctx, id)
+		}
+		if err != nil {
+			return err
+		}
+
+		// Delete and Insert game_genres
+		query = `DELETE FROM games_genres
+				WHERE game_id = $1;
+				`
+
+		_, err = tx.exec(ctx, query, id)
+		if err != nil {
+			return err
+		}
+
+		query = `INSERT INTO games_genres (game_id, genre_id, created_at, updated_at)
+					VALUES ($1, $2, NOW(), NOW())
+					ON CONFLICT DO NOTHING
+				`
+		for _, genreID := range genres {
+			_, err = tx.exec(ctx, query, id, genreID)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Delete and Insert game_modes
+		query = `DELETE FROM games_modes
+				WHERE game_id = $1;
+				`
+
+		_, err = tx.exec(ctx, query, id)
+		if err != nil {
+			return err
+		}
+
+		query = `INSERT INTO games_modes (game_id, mode_id, created_at, updated_at)
+					VALUES ($1, $2, NOW(), NOW())
+					ON CONFLICT DO NOTHING
+				`
+		for _, modeID := range modes {
+			_, err = tx.exec(ctx, query, id, modeID)
+			if err != nil {
+				return err
+			}
+		}
+
+		after, err := tx.gameSnapshot(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		return tx.audit(ctx, AuditGame, id, AuditUpdate, before, after)
+	})
 	if err != nil {
 		return err
 	}
 
-	// Insert game_genres
-	for _, genreID := range genres {
-		query = fmt.Sprintf(`INSERT INTO games_genres (game_id, genre_id, created_at, updated_at)`+
-			` VALUES (%d, %d, NOW(), NOW())`, gameID, genreID)
+	m.logger().Info("game updated", "game_id", id)
+
+	return nil
+}
+
+// PatchGame writes the differences between the current game and the patched one, touching only the
+// columns that changed and the genres and modes added or removed. The image is not changed.
+// It returns ErrEditConflict if the game no longer has the version of current, and sets
+// patched.Version to the new version. Nothing is written if nothing changed
+func (m *DBModels) PatchGame(ctx context.Context, current, patched *Game) error {
+	defer m.observe("PatchGame", time.Now())
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
 
-		_, err = m.DB.ExecContext(ctx, query)
+	var columns []string
+	var args []interface{}
+	set := func(column string, value interface{}) {
+		args = append(args, value)
+		columns = append(columns, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+
+	if patched.Title != current.Title {
+		set("title", patched.Title)
+	}
+	if !equalStrings(patched.Developers, current.Developers) {
+		set("developers", pq.Array(patched.Developers))
+	}
+	if !equalStrings(patched.Publishers, current.Publishers) {
+		set("publishers", pq.Array(patched.Publishers))
+	}
+	releaseDate := patched.ReleaseDate.UTC().Format("2006-01-02")
+	if releaseDate != current.ReleaseDate.UTC().Format("2006-01-02") {
+		set("release_date", releaseDate)
+	}
+	if patched.Storage != current.Storage {
+		set("storage", patched.Storage)
+	}
+	if patched.Likes != current.Likes {
+		set("likes", patched.Likes)
+	}
+
+	if len(columns) == 0 && equalIDs(patched.Genres, current.Genres) && equalIDs(patched.Modes, current.Modes) {
+		patched.Version = current.Version
+		return nil
+	}
+
+	err := m.withTx(ctx, func(tx *DBModels) error {
+		// la version se comprueba y sube aunque solo cambien generos o modos
+		columns = append(columns, "version = version + 1", "updated_at = NOW()")
+		args = append(args, current.ID, current.Version)
+		query := fmt.Sprintf(`UPDATE games SET %s WHERE id = $%d AND version = $%d AND deleted_at IS NULL RETURNING version`,
+			strings.Join(columns, ", "), len(args)-1, len(args))
+
+		err := tx.queryRow(ctx, query, args...).Scan(&patched.Version)
+		if err == sql.ErrNoRows {
+			return tx.versionError(ctx, current.ID)
+		}
 		if err != nil {
 			return err
 		}
+
+		for genreID := range patched.Genres {
+			if _, ok := current.Genres[genreID]; !ok {
+				_, err = tx.exec(ctx, `INSERT INTO games_genres (game_id, genre_id, created_at, updated_at)
+						VALUES ($1, $2, NOW(), NOW()) ON CONFLICT DO NOTHING`, current.ID, genreID)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		for genreID := range current.Genres {
+			if _, ok := patched.Genres[genreID]; !ok {
+				_, err = tx.exec(ctx, `DELETE FROM games_genres WHERE game_id = $1 AND genre_id = $2`, current.ID, genreID)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		for modeID := range patched.Modes {
+			if _, ok := current.Modes[modeID]; !ok {
+				_, err = tx.exec(ctx, `INSERT INTO games_modes (game_id, mode_id, created_at, updated_at)
+						VALUES ($1, $2, NOW(), NOW()) ON CONFLICT DO NOTHING`, current.ID, modeID)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		for modeID := range current.Modes {
+			if _, ok := patched.Modes[modeID]; !ok {
+				_, err = tx.exec(ctx, `DELETE FROM games_modes WHERE game_id = $1 AND mode_id = $2`, current.ID, modeID)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return tx.audit(ctx, AuditGame, current.ID, AuditUpdate, gameAuditFields(current), gameAuditFields(patched))
+	})
+	if err != nil {
+		return err
+	}
+
+	m.logger().Info("game patched", "game_id", current.ID, "version", patched.Version)
+
+	return nil
+}
+
+// equalIDs tells whether two sets of genres or modes hold the same ids
+func equalIDs(a, b map[int]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for id := range a {
+		if _, ok := b[id]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// equalStrings tells whether two lists hold the same strings in the same order
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
 	}
 
-	// Insert game_modes
-	for _, modeID := range modes {
-		query = fmt.Sprintf(`INSERT INTO games_modes (game_id, mode_id, created_at, updated_at)`+
-			` VALUES (%d, %d, NOW(), NOW())`, gameID, modeID)
+	return true
+}
+
+// UpdateGameImage sets the image of a game, or clears it if image is empty. The image hash is reset.
+// If version is not zero the game is only changed if it still has that version, returning ErrEditConflict otherwise
+func (m *DBModels) UpdateGameImage(ctx context.Context, id int, image string, version int) error {
+	defer m.observe("UpdateGameImage", time.Now())
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
 
-		_, err = m.DB.ExecContext(ctx, query)
+	// old es la fila antes del UPDATE, para el registro de auditoria
+	query := `UPDATE games g SET image_url = $1, image_hash = NULL, image_sha256 = NULL, image_size = NULL, version = g.version + 1, updated_at = NOW()
+			FROM games old
+			WHERE g.id = $2 AND old.id = g.id AND g.deleted_at IS NULL AND ($3 = 0 OR g.version = $3)
+			RETURNING old.image_url;
+			`
+
+	return m.withTx(ctx, func(tx *DBModels) error {
+		var previous string
+		err := tx.queryRow(ctx, query, image, id, version).Scan(&previous)
+		if err == sql.ErrNoRows {
+			return tx.versionError(ctx, id)
+		}
 		if err != nil {
 			return err
 		}
+
+		return tx.audit(ctx, AuditGame, id, AuditUpdate, map[string]interface{}{"image_url": previous}, map[string]interface{}{"image_url": image})
+	})
+}
+
+// GetAllImageHashes returns the perceptual hash of every hashed cover, by game id, and error, if any.
+// The covers of the games in the trash are left out, so they do not block new uploads as duplicates
+func (m *DBModels) GetAllImageHashes(ctx context.Context) (map[int]uint64, error) {
+	defer m.observe("GetAllImageHashes", time.Now())
+	ctx, cancel := m.bulkContext(ctx)
+	defer cancel()
+
+	query := `SELECT id, image_hash
+				FROM games
+				WHERE image_hash IS NOT NULL AND deleted_at IS NULL
+			`
+
+	rows, err := m.query(ctx, query)
+	if err != nil {
+		return nil, err
 	}
-	return err
+	defer rows.Close()
+
+	hashes := make(map[int]uint64)
+	for rows.Next() {
+		var id int
+		var hash int64
+		err := rows.Scan(
+			&id,
+			&hash,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		hashes[id] = uint64(hash)
+	}
+
+	return hashes, rows.Err()
 }
 
-func (m *DBModels) UpdateGame(id int, game *Game, genres []int, modes []int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// SetGameImageHash stores the perceptual hash and the checksum of the cover of a game, or clears them if nil
+func (m *DBModels) SetGameImageHash(ctx context.Context, id int, hash *uint64, checksum *ImageChecksum) error {
+	defer m.observe("SetGameImageHash", time.Now())
+	ctx, cancel := m.writeContext(ctx)
 	defer cancel()
 
-	// Insert game
-	query := fmt.Sprintf(`UPDATE games SET title = '%s', image_url = '%s', developers = $1, publishers = $2, release_date = $3,`+
-		` storage = %d, likes = %d, updated_at = NOW()`, game.Title, game.ImageUrl, game.Storage, game.Likes)
+	// postgres no tiene enteros sin signo: se guarda el mismo patron de bits como BIGINT
+	var value sql.NullInt64
+	if hash != nil {
+		value = sql.NullInt64{Int64: int64(*hash), Valid: true}
+	}
+	var sum sql.NullString
+	var size sql.NullInt64
+	if checksum != nil {
+		sum = sql.NullString{String: checksum.SHA256, Valid: true}
+		size = sql.NullInt64{Int64: checksum.Size, Valid: true}
+	}
+
+	query := `UPDATE games SET image_hash = $1, image_sha256 = $2, image_size = $3
+			WHERE id = $4;
+			`
 
-	_, err := m.DB.ExecContext(ctx, query, pq.Array(game.Publishers), pq.Array(game.Developers), game.ReleaseDate.UTC().Format("2006-01-02"))
+	_, err := m.exec(ctx, query, value, sum, size, id)
 	if err != nil {
 		return err
 	}
 
-	// Delete and Insert game_genres
-	query = `DELETE FROM games_genres
-			WHERE game_id = $1;
+	return nil
+}
+
+// GetAllImageChecksums returns the stored checksum of every cover of the games that are not in the trash,
+// by game id, and error, if any. Covers uploaded before checksums were stored are left out
+func (m *DBModels) GetAllImageChecksums(ctx context.Context) (map[int]ImageChecksum, error) {
+	defer m.observe("GetAllImageChecksums", time.Now())
+	ctx, cancel := m.bulkContext(ctx)
+	defer cancel()
+
+	query := `SELECT id, image_url, image_sha256, image_size
+				FROM games
+				WHERE image_sha256 IS NOT NULL AND image_size IS NOT NULL AND deleted_at IS NULL
 			`
 
-	_, err = m.DB.ExecContext(ctx, query, id)
+	rows, err := m.query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checksums := make(map[int]ImageChecksum)
+	for rows.Next() {
+		var id int
+		var checksum ImageChecksum
+		err := rows.Scan(
+			&id,
+			&checksum.File,
+			&checksum.SHA256,
+			&checksum.Size,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		checksums[id] = checksum
+	}
+
+	return checksums, rows.Err()
+}
+
+// SetGameImageChecksum stores the checksum of the cover of a game, as long as the game still uses checksum.File
+func (m *DBModels) SetGameImageChecksum(ctx context.Context, id int, checksum ImageChecksum) error {
+	defer m.observe("SetGameImageChecksum", time.Now())
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	// si la portada cambio mientras se calculaba, la suma ya no es suya
+	query := `UPDATE games SET image_sha256 = $1, image_size = $2
+			WHERE id = $3 AND image_url = $4;
+			`
+
+	_, err := m.exec(ctx, query, checksum.SHA256, checksum.Size, id, checksum.File)
 	if err != nil {
 		return err
 	}
 
-	for _, genreID := range genres {
-		query = fmt.Sprintf(`INSERT INTO games_genres (game_id, genre_id, created_at, updated_at)`+
-			` VALUES (%d, %d, NOW(), NOW()) ON CONFLICT DO NOTHING`, id, genreID)
+	return nil
+}
+
+// DeleteGame moves a game to the trash, where it is hidden until it is restored or purged. If version is
+// not zero the game is only deleted if it still has that version, returning ErrEditConflict otherwise
+func (m *DBModels) DeleteGame(ctx context.Context, id int, version int) error {
+	defer m.observe("DeleteGame", time.Now())
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	err := m.withTx(ctx, func(tx *DBModels) error {
+		// Delete game
+		query := `UPDATE games SET deleted_at = NOW(), version = version + 1, updated_at = NOW()
+				WHERE id = $1 AND deleted_at IS NULL AND ($2 = 0 OR version = $2);
+				`
 
-		_, err = m.DB.ExecContext(ctx, query)
+		result, err := tx.exec(ctx, query, id, version)
 		if err != nil {
 			return err
 		}
-	}
 
-	// Delete and Insert game_modes
-	query = `DELETE FROM games_modes
-			WHERE game_id = $1;
-			`
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return tx.versionError(ctx, id)
+		}
 
-	_, err = m.DB.ExecContext(ctx, query, id)
+		before, err := tx.gameSnapshot(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		return tx.audit(ctx, AuditGame, id, AuditDelete, before, nil)
+	})
 	if err != nil {
 		return err
 	}
 
-	for _, modeID := range modes {
-		query = fmt.Sprintf(`INSERT INTO games_modes (game_id, mode_id, created_at, updated_at)`+
-			` VALUES (%d, %d, NOW(), NOW()) ON CONFLICT DO NOTHING`, id, modeID)
+	m.logger().Info("game moved to trash", "game_id", id)
+
+	return nil
+}
+
+// RestoreGame takes a game out of the trash
+func (m *DBModels) RestoreGame(ctx context.Context, id int) error {
+	defer m.observe("RestoreGame", time.Now())
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	err := m.withTx(ctx, func(tx *DBModels) error {
+		query := `UPDATE games SET deleted_at = NULL, version = version + 1, updated_at = NOW()
+				WHERE id = $1 AND deleted_at IS NOT NULL;
+				`
+
+		result, err := tx.exec(ctx, query, id)
+		if err != nil {
+			return err
+		}
+
+		err = checkAffected(result)
+		if err != nil {
+			return err
+		}
 
-		_, err = m.DB.ExecContext(ctx, query)
+		after, err := tx.gameSnapshot(ctx, id)
 		if err != nil {
 			return err
 		}
+
+		return tx.audit(ctx, AuditGame, id, AuditRestore, nil, after)
+	})
+	if err != nil {
+		return err
 	}
 
-	fmt.Println("UPDATED")
+	m.logger().Info("game restored", "game_id", id)
 
-	return err
+	return nil
 }
 
-func (m *DBModels) DeleteGame(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// GetTrashedGames returns the games in the trash, the most recently deleted first, and error, if any
+func (m *DBModels) GetTrashedGames(ctx context.Context) ([]*Game, error) {
+	defer m.observe("GetTrashedGames", time.Now())
+	ctx, cancel := m.readContext(ctx)
 	defer cancel()
 
-	// Delete game
-	query := `DELETE FROM games
-			WHERE id = $1;
+	query := `SELECT id, title, image_url, deleted_at
+				FROM games
+				WHERE deleted_at IS NOT NULL
+				ORDER BY deleted_at DESC
 			`
 
-	_, err := m.DB.ExecContext(ctx, query, id)
+	rows, err := m.query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	games := []*Game{}
+	for rows.Next() {
+		var game Game
+		err := rows.Scan(
+			&game.ID,
+			&game.Title,
+			&game.ImageUrl,
+			&game.DeletedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		games = append(games, &game)
+	}
+
+	return games, rows.Err()
+}
+
+// PurgeDeletedGames permanently deletes the games moved to the trash before the given time, with their
+// genres, modes and media. It returns the image files the purged games used (covers and media)
+func (m *DBModels) PurgeDeletedGames(ctx context.Context, before time.Time) ([]string, error) {
+	defer m.observe("PurgeDeletedGames", time.Now())
+	ctx, cancel := m.bulkContext(ctx)
+	defer cancel()
+
+	var files []string
+	err := m.withTx(ctx, func(tx *DBModels) error {
+		// los juegos se bloquean para que nadie los restaure mientras se purgan
+		query := `SELECT id, image_url
+					FROM games
+					WHERE deleted_at < $1
+					ORDER BY id
+					FOR UPDATE
+				`
+
+		ids, covers, err := tx.gameFiles(ctx, query, before)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		// los ficheros de la galeria se leen antes de que el borrado en cascada se lleve sus filas
+		query = `SELECT game_id, file_name
+					FROM game_media
+					WHERE game_id = ANY($1)
+				`
+
+		_, media, err := tx.gameFiles(ctx, query, pq.Array(ids))
+		if err != nil {
+			return err
+		}
+		files = append(covers, media...)
+
+		snapshots := make(map[int]map[string]interface{}, len(ids))
+		for _, id := range ids {
+			snapshots[id], err = tx.gameSnapshot(ctx, id)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.exec(ctx, `DELETE FROM games WHERE id = ANY($1)`, pq.Array(ids))
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			err = tx.audit(ctx, AuditGame, id, AuditPurge, snapshots[id], nil)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// gameFiles runs a query returning the id of a game and a file, and returns the ids and the files that are not empty
+func (m *DBModels) gameFiles(ctx context.Context, query string, args ...interface{}) ([]int, []string, error) {
+	rows, err := m.query(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	var files []string
+	for rows.Next() {
+		var id int
+		var file string
+		err := rows.Scan(&id, &file)
+		if err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+
+	return ids, files, rows.Err()
+}
+
+// GetGameVersion returns the version of a game, which changes every time the game is written
+func (m *DBModels) GetGameVersion(ctx context.Context, id int) (int, error) {
+	defer m.observe("GetGameVersion", time.Now())
+	ctx, cancel := m.readContext(ctx)
+	defer cancel()
+
+	var version int
+	err := m.queryRow(ctx, `SELECT version FROM games WHERE id = $1 AND deleted_at IS NULL`, id).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// versionError tells why a write conditioned on the version of a game matched no row:
+// sql.ErrNoRows if the game does not exist, or ErrEditConflict if it has another version
+func (m *DBModels) versionError(ctx context.Context, id int) error {
+	var exists bool
+	err := m.queryRow(ctx, `SELECT EXISTS (SELECT 1 FROM games WHERE id = $1 AND deleted_at IS NULL)`, id).Scan(&exists)
 	if err != nil {
 		return err
 	}
+	if !exists {
+		return sql.ErrNoRows
+	}
 
-	return nil
+	return ErrEditConflict
 }
 
-// Reusable private function
+// touchGame gives a new version to a game whose media changed, as the media is part of the game. If version
+// is not zero the game must still have it, or ErrEditConflict is returned and the change is rolled back.
+// A game in the trash cannot change: it returns sql.ErrNoRows
+func (m *DBModels) touchGame(ctx context.Context, id int, version int) error {
+	var newVersion int
+	err := m.queryRow(ctx, `UPDATE games SET version = version + 1 WHERE id = $1 AND deleted_at IS NULL AND ($2 = 0 OR version = $2) RETURNING version`,
+		id, version).Scan(&newVersion)
+	if err == sql.ErrNoRows {
+		return m.versionError(ctx, id)
+	}
+
+	return err
+}
+
+// getGamesFromRows reads the games of rows, then loads the genres, modes and media of all of them with one
+// query each. rows is closed before those queries, so a listing never holds more than one connection
 func (m *DBModels) getGamesFromRows(ctx context.Context, rows *sql.Rows) ([]*Game, error) {
+	defer rows.Close()
+
 	var games []*Game
+	byID := make(map[int]*Game)
+	var ids []int64
 	for rows.Next() {
 
 		var game Game
@@ -348,69 +1052,118 @@ func (m *DBModels) getGamesFromRows(ctx context.Context, rows *sql.Rows) ([]*Gam
 			&game.ReleaseDate,
 			&game.Storage,
 			&game.Likes,
+			&game.Version,
 			&game.CreatedAt,
 			&game.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
 		}
+		game.Genres = make(map[int]string)
+		game.Modes = make(map[int]string)
+		game.Media = []*Media{}
 
-		// get genres, if any
-		query := `SELECT gg.genre_id, g.genre_name
-					FROM games_genres gg
-						LEFT JOIN genres g ON (g.id = gg.genre_id)
-					WHERE gg.game_id = $1
-				`
+		games = append(games, &game)
+		byID[game.ID] = &game
+		ids = append(ids, int64(game.ID))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if len(games) == 0 {
+		return games, nil
+	}
 
-		rows2, err := m.DB.QueryContext(ctx, query, game.ID)
+	// get genres, if any
+	query := `SELECT gg.game_id, gg.genre_id, g.genre_name
+				FROM games_genres gg
+					LEFT JOIN genres g ON (g.id = gg.genre_id)
+				WHERE gg.game_id = ANY($1)
+			`
+
+	err := m.scanEach(ctx, func(rows *sql.Rows) error {
+		var gameID int
+		var g Genre
+		err := rows.Scan(
+			&gameID,
+			&g.ID,
+			&g.GenreName,
+		)
 		if err != nil {
-			return nil, err
+			return err
 		}
+		byID[gameID].Genres[g.ID] = g.GenreName
+		return nil
+	}, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
 
-		genres := make(map[int]string)
-		for rows2.Next() {
-			var g Genre
-			err := rows2.Scan(
-				&g.ID,
-				&g.GenreName,
-			)
-			if err != nil {
-				return nil, err
-			}
-			genres[g.ID] = g.GenreName
+	// get modes, if any
+	query = `SELECT gm.game_id, gm.mode_id, m.mode_name
+				FROM games_modes gm
+					LEFT JOIN modes m ON (m.id = gm.mode_id)
+				WHERE gm.game_id = ANY($1)
+			`
+
+	err = m.scanEach(ctx, func(rows *sql.Rows) error {
+		var gameID int
+		var mode Mode
+		err := rows.Scan(
+			&gameID,
+			&mode.ID,
+			&mode.ModeName,
+		)
+		if err != nil {
+			return err
 		}
-		game.Genres = genres
+		byID[gameID].Modes[mode.ID] = mode.ModeName
+		return nil
+	}, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
 
-		// get modes, if any
-		query = `SELECT gm.mode_id, m.mode_name
-					FROM games_modes gm
-						LEFT JOIN modes m ON (m.id = gm.mode_id)
-					WHERE gm.game_id = $1
-				`
+	// get media, if any
+	query = `SELECT id, game_id, kind, file_name, caption, position, created_at, updated_at
+				FROM game_media
+				WHERE game_id = ANY($1)
+				ORDER BY game_id, position, id
+			`
 
-		rows2, err = m.DB.QueryContext(ctx, query, game.ID)
+	err = m.scanEach(ctx, func(rows *sql.Rows) error {
+		item, err := scanMedia(rows)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		defer rows2.Close()
+		byID[item.GameID].Media = append(byID[item.GameID].Media, item)
+		return nil
+	}, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
 
-		modes := make(map[int]string)
-		for rows2.Next() {
-			var m Mode
-			err := rows2.Scan(
-				&m.ID,
-				&m.ModeName,
-			)
-			if err != nil {
-				return nil, err
-			}
-			modes[m.ID] = m.ModeName
+	return games, nil
+}
+
+// scanEach runs query and calls scan for each of its rows
+func (m *DBModels) scanEach(ctx context.Context, scan func(rows *sql.Rows) error, query string, args ...interface{}) error {
+	rows, err := m.query(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		err := scan(rows)
+		if err != nil {
+			return err
 		}
-		game.Modes = modes
-		games = append(games, &game)
 	}
 
-	return games, nil
+	return rows.Err()
 }
 
 // Reusable private function
@@ -426,6 +1179,7 @@ func (m *DBModels) getGameFromRow(ctx context.Context, row *sql.Row, id int) (*G
 		&game.ReleaseDate,
 		&game.Storage,
 		&game.Likes,
+		&game.Version,
 		&game.CreatedAt,
 		&game.UpdatedAt,
 	)
@@ -440,24 +1194,22 @@ func (m *DBModels) getGameFromRow(ctx context.Context, row *sql.Row, id int) (*G
 				WHERE gg.game_id = $1
 			`
 
-	rows, err := m.DB.QueryContext(ctx, query, id)
-	if err != nil {
-		return nil, err
-	}
-
-	genres := make(map[int]string)
-	for rows.Next() {
+	game.Genres = make(map[int]string)
+	err = m.scanEach(ctx, func(rows *sql.Rows) error {
 		var g Genre
 		err := rows.Scan(
 			&g.ID,
 			&g.GenreName,
 		)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		genres[g.ID] = g.GenreName
+		game.Genres[g.ID] = g.GenreName
+		return nil
+	}, query, id)
+	if err != nil {
+		return nil, err
 	}
-	game.Genres = genres
 
 	// get modes, if any
 	query = `SELECT gm.mode_id, m.mode_name
@@ -466,25 +1218,29 @@ func (m *DBModels) getGameFromRow(ctx context.Context, row *sql.Row, id int) (*G
 				WHERE gm.game_id = $1
 			`
 
-	rows, err = m.DB.QueryContext(ctx, query, id)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	modes := make(map[int]string)
-	for rows.Next() {
-		var m Mode
+	game.Modes = make(map[int]string)
+	err = m.scanEach(ctx, func(rows *sql.Rows) error {
+		var mode Mode
 		err := rows.Scan(
-			&m.ID,
-			&m.ModeName,
+			&mode.ID,
+			&mode.ModeName,
 		)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		modes[m.ID] = m.ModeName
+		game.Modes[mode.ID] = mode.ModeName
+		return nil
+	}, query, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// get media, if any
+	media, err := m.getGameMedia(ctx, id)
+	if err != nil {
+		return nil, err
 	}
-	game.Modes = modes
+	game.Media = media
 
 	return &game, nil
 }
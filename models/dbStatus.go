@@ -0,0 +1,51 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// DBStatus describes the database server and the data it holds
+type DBStatus struct {
+	ServerVersion string `json:"server_version"`
+	Migration     int    `json:"migration"`
+	Games         int    `json:"games"`
+	TrashedGames  int    `json:"trashed_games"`
+	Genres        int    `json:"genres"`
+	Modes         int    `json:"modes"`
+}
+
+// GetDBStatus returns the server version, the last migration applied and the number of games (in and out of
+// the trash), genres and modes
+func (m *DBModels) GetDBStatus(ctx context.Context) (*DBStatus, error) {
+	defer m.observe("GetDBStatus", time.Now())
+	ctx, cancel := m.readContext(ctx)
+	defer cancel()
+
+	var status DBStatus
+
+	err := m.queryRow(ctx, `SHOW server_version`).Scan(&status.ServerVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`
+
+	err = m.queryRow(ctx, query).Scan(&status.Migration)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `SELECT (SELECT COUNT(*) FROM games WHERE deleted_at IS NULL),
+					(SELECT COUNT(*) FROM games WHERE deleted_at IS NOT NULL),
+					(SELECT COUNT(*) FROM genres),
+					(SELECT COUNT(*) FROM modes)
+			`
+
+	err = m.queryRow(ctx, query).Scan(&status.Games, &status.TrashedGames, &status.Genres, &status.Modes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
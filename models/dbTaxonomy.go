@@ -0,0 +1,152 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// InsertGenre adds a genre and returns its id
+func (m *DBModels) InsertGenre(ctx context.Context, name string) (int, error) {
+	defer m.observe("InsertGenre", time.Now())
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	query := `INSERT INTO genres (genre_name, created_at, updated_at)
+				VALUES ($1, NOW(), NOW())
+				RETURNING id
+			`
+
+	var id int
+	err := m.withTx(ctx, func(tx *DBModels) error {
+		err := tx.queryRow(ctx, query, name).Scan(&id)
+		if err != nil {
+			return err
+		}
+
+		return tx.audit(ctx, AuditGenre, id, AuditInsert, nil, map[string]interface{}{"genre_name": name})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// UpdateGenre renames a genre
+func (m *DBModels) UpdateGenre(ctx context.Context, id int, name string) error {
+	defer m.observe("UpdateGenre", time.Now())
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	// old es la fila antes del UPDATE, para el registro de auditoria
+	query := `UPDATE genres t SET genre_name = $1, updated_at = NOW()
+			FROM genres old
+			WHERE t.id = $2 AND old.id = t.id
+			RETURNING old.genre_name;
+			`
+
+	return m.withTx(ctx, func(tx *DBModels) error {
+		var previous string
+		err := tx.queryRow(ctx, query, name, id).Scan(&previous)
+		if err != nil {
+			return err
+		}
+
+		return tx.audit(ctx, AuditGenre, id, AuditUpdate, map[string]interface{}{"genre_name": previous}, map[string]interface{}{"genre_name": name})
+	})
+}
+
+// DeleteGenre deletes a genre
+func (m *DBModels) DeleteGenre(ctx context.Context, id int) error {
+	defer m.observe("DeleteGenre", time.Now())
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	query := `DELETE FROM genres
+			WHERE id = $1
+			RETURNING genre_name;
+			`
+
+	return m.withTx(ctx, func(tx *DBModels) error {
+		var previous string
+		err := tx.queryRow(ctx, query, id).Scan(&previous)
+		if err != nil {
+			return err
+		}
+
+		return tx.audit(ctx, AuditGenre, id, AuditDelete, map[string]interface{}{"genre_name": previous}, nil)
+	})
+}
+
+// InsertMode adds a mode and returns its id
+func (m *DBModels) InsertMode(ctx context.Context, name string) (int, error) {
+	defer m.observe("InsertMode", time.Now())
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	query := `INSERT INTO modes (mode_name, created_at, updated_at)
+				VALUES ($1, NOW(), NOW())
+				RETURNING id
+			`
+
+	var id int
+	err := m.withTx(ctx, func(tx *DBModels) error {
+		err := tx.queryRow(ctx, query, name).Scan(&id)
+		if err != nil {
+			return err
+		}
+
+		return tx.audit(ctx, AuditMode, id, AuditInsert, nil, map[string]interface{}{"mode_name": name})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// UpdateMode renames a mode
+func (m *DBModels) UpdateMode(ctx context.Context, id int, name string) error {
+	defer m.observe("UpdateMode", time.Now())
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	// old es la fila antes del UPDATE, para el registro de auditoria
+	query := `UPDATE modes t SET mode_name = $1, updated_at = NOW()
+			FROM modes old
+			WHERE t.id = $2 AND old.id = t.id
+			RETURNING old.mode_name;
+			`
+
+	return m.withTx(ctx, func(tx *DBModels) error {
+		var previous string
+		err := tx.queryRow(ctx, query, name, id).Scan(&previous)
+		if err != nil {
+			return err
+		}
+
+		return tx.audit(ctx, AuditMode, id, AuditUpdate, map[string]interface{}{"mode_name": previous}, map[string]interface{}{"mode_name": name})
+	})
+}
+
+// DeleteMode deletes a mode
+func (m *DBModels) DeleteMode(ctx context.Context, id int) error {
+	defer m.observe("DeleteMode", time.Now())
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	query := `DELETE FROM modes
+			WHERE id = $1
+			RETURNING mode_name;
+			`
+
+	return m.withTx(ctx, func(tx *DBModels) error {
+		var previous string
+		err := tx.queryRow(ctx, query, id).Scan(&previous)
+		if err != nil {
+			return err
+		}
+
+		return tx.audit(ctx, AuditMode, id, AuditDelete, map[string]interface{}{"mode_name": previous}, nil)
+	})
+}
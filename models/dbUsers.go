@@ -0,0 +1,157 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// GetAllUsers returns all users and error, if any
+func (m *DBModels) GetAllUsers(ctx context.Context) ([]*User, error) {
+	defer m.observe("GetAllUsers", time.Now())
+	ctx, cancel := m.readContext(ctx)
+	defer cancel()
+
+	query := `SELECT id, username, password_hash, role, token_version, created_at, updated_at
+				FROM users
+				ORDER BY username
+			`
+
+	rows, err := m.query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&user.ID,
+			&user.Username,
+			&user.PasswordHash,
+			&user.Role,
+			&user.TokenVersion,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+
+	return users, rows.Err()
+}
+
+// GetOneUser returns one user by id and error, if any
+func (m *DBModels) GetOneUser(ctx context.Context, id int) (*User, error) {
+	defer m.observe("GetOneUser", time.Now())
+	ctx, cancel := m.readContext(ctx)
+	defer cancel()
+
+	query := `SELECT id, username, password_hash, role, token_version, created_at, updated_at
+				FROM users
+				WHERE id = $1
+			`
+
+	return scanUser(m.queryRow(ctx, query, id))
+}
+
+// GetUserByUsername returns one user by username and error, if any
+func (m *DBModels) GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	defer m.observe("GetUserByUsername", time.Now())
+	ctx, cancel := m.readContext(ctx)
+	defer cancel()
+
+	query := `SELECT id, username, password_hash, role, token_version, created_at, updated_at
+				FROM users
+				WHERE username = $1
+			`
+
+	return scanUser(m.queryRow(ctx, query, username))
+}
+
+// InsertUser adds a user and sets its id
+func (m *DBModels) InsertUser(ctx context.Context, user *User) error {
+	defer m.observe("InsertUser", time.Now())
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	query := `INSERT INTO users (username, password_hash, role, created_at, updated_at)
+				VALUES ($1, $2, $3, NOW(), NOW())
+				RETURNING id
+			`
+
+	row := m.queryRow(ctx, query, user.Username, user.PasswordHash, user.Role)
+	err := row.Scan(
+		&user.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateUser changes the username, password hash and role of a user. A new password or role raises
+// the token version of the user, which revokes the tokens signed before
+func (m *DBModels) UpdateUser(ctx context.Context, user *User) error {
+	defer m.observe("UpdateUser", time.Now())
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	query := `UPDATE users SET username = $1, password_hash = $2, role = $3, updated_at = NOW(),
+				token_version = token_version + CASE WHEN password_hash <> $2 OR role <> $3 THEN 1 ELSE 0 END
+			WHERE id = $4
+			RETURNING token_version, updated_at;
+			`
+
+	err := m.queryRow(ctx, query, user.Username, user.PasswordHash, user.Role, user.ID).Scan(
+		&user.TokenVersion,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteUser deletes a user
+func (m *DBModels) DeleteUser(ctx context.Context, id int) error {
+	defer m.observe("DeleteUser", time.Now())
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	query := `DELETE FROM users
+			WHERE id = $1;
+			`
+
+	result, err := m.exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(result)
+}
+
+// Reusable private function
+func scanUser(row *sql.Row) (*User, error) {
+	var user User
+
+	err := row.Scan(
+		&user.ID,
+		&user.Username,
+		&user.PasswordHash,
+		&user.Role,
+		&user.TokenVersion,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
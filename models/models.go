@@ -2,6 +2,7 @@ package models
 
 import (
 	"database/sql"
+	"log/slog"
 	"time"
 )
 
@@ -11,9 +12,9 @@ type Models struct {
 }
 
 // NewModels returns models with db pool
-func NewModels(db *sql.DB) Models {
+func NewModels(db *sql.DB, logger *slog.Logger) Models {
 	return Models{
-		DB: DBModels{DB: db},
+		DB: DBModels{DB: db, Logger: logger},
 	}
 }
 
@@ -24,13 +25,16 @@ type Game struct {
 	ImageUrl    string         `json:"image_url"`
 	Genres      map[int]string `json:"genres"`
 	Modes       map[int]string `json:"modes"`
+	Media       []*Media       `json:"media"`
 	Developers  []string       `json:"developers"`
 	Publishers  []string       `json:"publishers"`
 	ReleaseDate time.Time      `json:"release_date"`
 	Storage     int            `json:"storage"`
 	Likes       int            `json:"likes"`
+	Version     int            `json:"version"`
 	CreatedAt   time.Time      `json:"-"`
 	UpdatedAt   time.Time      `json:"-"`
+	DeletedAt   *time.Time     `json:"deleted_at,omitempty"`
 }
 
 // Genre is the type for genre
@@ -48,3 +52,80 @@ type Mode struct {
 	CreatedAt time.Time `json:"-"`
 	UpdatedAt time.Time `json:"-"`
 }
+
+// Media is the type for a screenshot or artwork of a game
+type Media struct {
+	ID        int       `json:"id"`
+	GameID    int       `json:"game_id"`
+	Kind      string    `json:"kind"`
+	FileName  string    `json:"-"`
+	Url       string    `json:"url"`
+	Caption   string    `json:"caption"`
+	Position  int       `json:"position"`
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"-"`
+}
+
+// ImageChecksum is the type for the SHA-256 and size of the cover file of a game
+type ImageChecksum struct {
+	File   string
+	SHA256 string
+	Size   int64
+}
+
+// User is the type for an account that can sign in
+type User struct {
+	ID           int       `json:"id"`
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"-"`
+	Role         string    `json:"role"`
+	TokenVersion int       `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// APIKey is the type for a key used by machine clients
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scope      string     `json:"scope"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedBy  *int       `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"-"`
+}
+
+// IdempotencyKey is a write request identified by its client and Idempotency-Key header, with the
+// response to send again if the request is retried. Status is zero while the request is running
+type IdempotencyKey struct {
+	Client      string
+	Key         string
+	RequestHash string
+	Status      int
+	Headers     map[string][]string
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// AuditEntry is one change of the catalog
+type AuditEntry struct {
+	ID        int64                  `json:"id"`
+	Entity    string                 `json:"entity"`
+	EntityID  int                    `json:"entity_id"`
+	Action    string                 `json:"action"`
+	Actor     string                 `json:"actor"`
+	RequestID string                 `json:"request_id,omitempty"`
+	Changes   map[string]AuditChange `json:"changes"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// AuditChange is the value of a field before and after a change
+type AuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
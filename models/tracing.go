@@ -0,0 +1,107 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name of the spans of the models
+const tracerName = "CRUDWeb/models"
+
+// tracer returns the tracer of the models, or the global one if none was set
+func (m *DBModels) tracer() trace.Tracer {
+	if m.Tracer != nil {
+		return m.Tracer
+	}
+
+	return otel.Tracer(tracerName)
+}
+
+// startSpan starts the span of one SQL statement, named after its operation (SELECT, INSERT...)
+func (m *DBModels) startSpan(ctx context.Context, query string) (context.Context, trace.Span) {
+	query = strings.TrimSpace(query)
+	operation := query
+	if i := strings.IndexAny(query, " \t\n"); i > 0 {
+		operation = query[:i]
+	}
+	operation = strings.ToUpper(operation)
+
+	return m.tracer().Start(ctx, operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.operation", operation),
+			attribute.String("db.statement", query),
+		),
+	)
+}
+
+// endStatement ends the span of a statement started at start, recording err if any, and logs it if it was slow
+func (m *DBModels) endStatement(span trace.Span, query string, start time.Time, err error) {
+	if err != nil && err != sql.ErrNoRows {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+
+	elapsed := time.Since(start)
+	if m.SlowQuery > 0 && elapsed > m.SlowQuery {
+		m.logger().Warn("slow query",
+			"statement", strings.Join(strings.Fields(query), " "),
+			"duration_ms", float64(elapsed.Microseconds())/1000,
+			"threshold_ms", m.SlowQuery.Milliseconds())
+	}
+}
+
+// conn runs the statements of the models: their transaction, if any, or the pool
+type conn interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// conn returns the transaction of the models, or the pool if they are not in one
+func (m *DBModels) conn() conn {
+	if m.tx != nil {
+		return m.tx
+	}
+
+	return m.DB
+}
+
+// query runs a query that returns rows inside its own span
+func (m *DBModels) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	ctx, span := m.startSpan(ctx, query)
+	rows, err := m.conn().QueryContext(ctx, query, args...)
+	m.endStatement(span, query, start, err)
+
+	return rows, err
+}
+
+// queryRow runs a query that returns at most one row inside its own span
+func (m *DBModels) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	ctx, span := m.startSpan(ctx, query)
+	row := m.conn().QueryRowContext(ctx, query, args...)
+	m.endStatement(span, query, start, row.Err())
+
+	return row
+}
+
+// exec runs a statement that returns no rows inside its own span
+func (m *DBModels) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	ctx, span := m.startSpan(ctx, query)
+	result, err := m.conn().ExecContext(ctx, query, args...)
+	m.endStatement(span, query, start, err)
+
+	return result, err
+}